@@ -0,0 +1,424 @@
+package collab
+
+import (
+	"errors"
+	"hyper-pen-service/utils"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// saveDelay 最后一次编辑后延迟保存的时间
+	saveDelay = 2 * time.Second
+	// maxHistory 房间内保留的历史操作数量，落后更多的客户端需要重新加载
+	maxHistory = 2000
+	// sendBuffer 每个客户端的发送队列长度
+	sendBuffer = 64
+)
+
+var (
+	// ErrConflict 保存时笔记已被其他途径修改
+	ErrConflict = errors.New("note has been modified")
+	// ErrForbidden 保存时编辑者已失去编辑权限
+	ErrForbidden = errors.New("no permission to edit note")
+)
+
+// Document 协同编辑的笔记内容
+type Document struct {
+	// EditorID 待保存的修改的作者，保存时以该用户的身份写入
+	EditorID uint
+	Title    string
+	Content  string
+	Version  int
+}
+
+// Store 协同编辑文档的加载与持久化
+type Store interface {
+	// Load 加载笔记当前内容
+	Load(noteID string) (*Document, error)
+	// Save 以 doc.EditorID 的身份、doc.Version 为期望版本保存正文，返回保存后的文档；
+	// 版本不一致时返回 ErrConflict，编辑者已没有编辑权限时返回 ErrForbidden
+	Save(noteID string, doc *Document) (*Document, error)
+	// CanEdit 检查用户当前是否仍可以编辑笔记
+	CanEdit(noteID string, userID uint) (bool, error)
+}
+
+// Participant 房间内的参与者
+type Participant struct {
+	ClientID string `json:"client_id"`
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// Message 服务器与客户端之间的消息
+type Message struct {
+	Type      string        `json:"type"`
+	Revision  int           `json:"revision"`
+	Operation *Operation    `json:"operation,omitempty"`
+	ClientID  string        `json:"client_id,omitempty"`
+	UserID    uint          `json:"user_id,omitempty"`
+	Content   string        `json:"content,omitempty"`
+	Title     string        `json:"title,omitempty"`
+	Version   int           `json:"version,omitempty"`
+	Users     []Participant `json:"users,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// 消息类型
+const (
+	MessageInit     = "init"
+	MessageOp       = "op"
+	MessageAck      = "ack"
+	MessagePresence = "presence"
+	MessageSaved    = "saved"
+	MessageError    = "error"
+)
+
+// Client 房间内的一个连接
+type Client struct {
+	Participant
+	Send chan Message
+}
+
+// Hub 管理所有正在协同编辑的笔记
+type Hub struct {
+	store Store
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+// NewHub 创建新的协同编辑中心
+func NewHub(store Store) *Hub {
+	return &Hub{store: store, rooms: make(map[string]*room)}
+}
+
+// room 一篇笔记的协同编辑状态
+type room struct {
+	hub     *Hub
+	noteID  string
+	mu      sync.Mutex
+	clients map[string]*Client
+
+	doc       Document
+	saved     string // 最后一次持久化的正文，用于冲突时的三方合并
+	revision  int
+	history   []*Operation
+	historyAt int // history[0] 对应的 revision
+	dirty     bool
+	editorID  uint // 待保存的修改的作者，修改只来自一个用户，作者变化时先保存
+	timer     *time.Timer
+}
+
+// Join 加入笔记的协同编辑，返回的客户端会先收到 init 消息
+func (h *Hub) Join(noteID string, userID uint, username string) (*Client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rooms[noteID]
+	if !ok {
+		doc, err := h.store.Load(noteID)
+		if err != nil {
+			return nil, err
+		}
+		r = &room{
+			hub:     h,
+			noteID:  noteID,
+			clients: make(map[string]*Client),
+			doc:     *doc,
+			saved:   doc.Content,
+		}
+		h.rooms[noteID] = r
+	}
+
+	client := &Client{
+		Participant: Participant{ClientID: uuid.New().String(), UserID: userID, Username: username},
+		Send:        make(chan Message, sendBuffer),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[client.ClientID] = client
+	client.Send <- Message{
+		Type:     MessageInit,
+		Revision: r.revision,
+		ClientID: client.ClientID,
+		Title:    r.doc.Title,
+		Content:  r.doc.Content,
+		Version:  r.doc.Version,
+		Users:    r.participants(),
+	}
+	r.broadcast(Message{Type: MessagePresence, Users: r.participants()}, "")
+	return client, nil
+}
+
+// Leave 离开协同编辑，最后一个人离开时立即保存并关闭房间
+func (h *Hub) Leave(noteID string, client *Client) {
+	h.mu.Lock()
+	r, ok := h.rooms[noteID]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+
+	r.mu.Lock()
+	if _, joined := r.clients[client.ClientID]; joined {
+		delete(r.clients, client.ClientID)
+		close(client.Send)
+	}
+	empty := len(r.clients) == 0
+	if empty {
+		delete(h.rooms, noteID)
+	}
+	h.mu.Unlock()
+
+	if empty {
+		if r.timer != nil {
+			r.timer.Stop()
+		}
+		r.save()
+	} else {
+		r.broadcast(Message{Type: MessagePresence, Users: r.participants()}, "")
+	}
+	r.mu.Unlock()
+}
+
+// Receive 处理客户端基于 revision 提交的操作
+func (h *Hub) Receive(noteID string, client *Client, revision int, op *Operation) {
+	h.mu.Lock()
+	r, ok := h.rooms[noteID]
+	h.mu.Unlock()
+	if !ok || op == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, joined := r.clients[client.ClientID]; !joined {
+		return
+	}
+	// 每次保存只包含一个用户的修改，修订版本记在实际编辑者名下，保存时也按该用户检查编辑权限
+	if r.dirty && r.editorID != client.UserID {
+		r.save()
+		if _, joined := r.clients[client.ClientID]; !joined {
+			return
+		}
+	}
+
+	if revision < r.historyAt || revision > r.revision {
+		r.send(client, Message{Type: MessageError, Error: "revision out of range, please reload"})
+		return
+	}
+
+	// 依次与客户端尚未看到的并发操作做变换
+	for _, concurrent := range r.history[revision-r.historyAt:] {
+		transformed, _, err := Transform(op, concurrent)
+		if err != nil {
+			r.send(client, Message{Type: MessageError, Error: err.Error()})
+			return
+		}
+		op = transformed
+	}
+
+	content, err := op.Apply(r.doc.Content)
+	if err != nil {
+		r.send(client, Message{Type: MessageError, Error: err.Error()})
+		return
+	}
+
+	r.apply(content, op)
+	r.editorID = client.UserID
+	r.send(client, Message{Type: MessageAck, Revision: r.revision})
+	r.broadcast(Message{Type: MessageOp, Revision: r.revision, Operation: op, ClientID: client.ClientID, UserID: client.UserID}, client.ClientID)
+	r.scheduleSave()
+}
+
+// apply 记录已应用的操作，调用方需持有 r.mu
+func (r *room) apply(content string, op *Operation) {
+	r.doc.Content = content
+	r.history = append(r.history, op)
+	r.revision++
+	if len(r.history) > maxHistory {
+		drop := len(r.history) - maxHistory
+		r.history = append([]*Operation(nil), r.history[drop:]...)
+		r.historyAt += drop
+	}
+	r.dirty = true
+}
+
+// scheduleSave 延迟保存，调用方需持有 r.mu
+func (r *room) scheduleSave() {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(saveDelay, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.save()
+	})
+}
+
+// save 以修改作者的身份持久化文档；如果笔记已被其他途径修改，则与服务器内容三方合并并把差异作为新操作广播；
+// 作者已失去编辑权限时撤销其未保存的修改并断开其连接。调用方需持有 r.mu
+func (r *room) save() {
+	if !r.dirty {
+		return
+	}
+
+	r.doc.EditorID = r.editorID
+	doc, err := r.hub.store.Save(r.noteID, &r.doc)
+	if err == ErrConflict {
+		doc, err = r.reconcile()
+	}
+	if err == ErrForbidden {
+		r.revert()
+		r.kick(r.editorID)
+		return
+	}
+	if err != nil {
+		log.Printf("协同编辑保存笔记失败 %s: %v", r.noteID, err)
+		return
+	}
+
+	r.doc.Version = doc.Version
+	r.doc.Title = doc.Title
+	r.saved = r.doc.Content
+	r.dirty = false
+	r.broadcast(Message{Type: MessageSaved, Revision: r.revision, Version: doc.Version, Title: doc.Title}, "")
+}
+
+// reconcile 合并服务器上的新内容后重新保存，调用方需持有 r.mu
+func (r *room) reconcile() (*Document, error) {
+	server, err := r.hub.store.Load(r.noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := utils.Merge3(r.saved, r.doc.Content, server.Content).Content
+	if merged != r.doc.Content {
+		op := FromDiff(r.doc.Content, merged)
+		r.apply(merged, op)
+		r.broadcast(Message{Type: MessageOp, Revision: r.revision, Operation: op}, "")
+	}
+
+	r.doc.Version = server.Version
+	return r.hub.store.Save(r.noteID, &r.doc)
+}
+
+// revert 撤销上次保存后的修改并广播，调用方需持有 r.mu
+func (r *room) revert() {
+	if r.doc.Content != r.saved {
+		op := FromDiff(r.doc.Content, r.saved)
+		r.apply(r.saved, op)
+		r.broadcast(Message{Type: MessageOp, Revision: r.revision, Operation: op}, "")
+	}
+	r.dirty = false
+}
+
+// kick 断开用户在房间内的所有连接，调用方需持有 r.mu
+func (r *room) kick(userID uint) {
+	kicked := false
+	for id, c := range r.clients {
+		if c.UserID != userID {
+			continue
+		}
+		select {
+		case c.Send <- Message{Type: MessageError, Error: ErrForbidden.Error()}:
+		default:
+		}
+		delete(r.clients, id)
+		close(c.Send)
+		kicked = true
+	}
+	if kicked {
+		r.broadcast(Message{Type: MessagePresence, Users: r.participants()}, "")
+	}
+}
+
+// Recheck 在用户的权限被修改或取消后重新检查其正在编辑的笔记，已不能编辑的笔记撤销其未保存的修改并断开连接
+func (h *Hub) Recheck(userID uint) {
+	h.mu.Lock()
+	rooms := make([]*room, 0, len(h.rooms))
+	for _, r := range h.rooms {
+		rooms = append(rooms, r)
+	}
+	h.mu.Unlock()
+
+	for _, r := range rooms {
+		r.mu.Lock()
+		if r.hasUser(userID) {
+			ok, err := h.store.CanEdit(r.noteID, userID)
+			if err != nil {
+				log.Printf("检查协同编辑权限失败 %s: %v", r.noteID, err)
+			} else if !ok {
+				if r.dirty && r.editorID == userID {
+					r.revert()
+				}
+				r.kick(userID)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// hasUser 用户是否有连接在房间内，调用方需持有 r.mu
+func (r *room) hasUser(userID uint) bool {
+	for _, c := range r.clients {
+		if c.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// participants 当前参与者列表，调用方需持有 r.mu
+func (r *room) participants() []Participant {
+	users := make([]Participant, 0, len(r.clients))
+	for _, c := range r.clients {
+		users = append(users, c.Participant)
+	}
+	return users
+}
+
+// broadcast 向除 except 以外的所有客户端发送消息，调用方需持有 r.mu
+func (r *room) broadcast(msg Message, except string) {
+	for id, c := range r.clients {
+		if id != except {
+			r.send(c, msg)
+		}
+	}
+}
+
+// send 非阻塞发送，发送队列已满的客户端会被断开，调用方需持有 r.mu
+func (r *room) send(c *Client, msg Message) {
+	if _, ok := r.clients[c.ClientID]; !ok {
+		return
+	}
+	select {
+	case c.Send <- msg:
+	default:
+		delete(r.clients, c.ClientID)
+		close(c.Send)
+	}
+}
+
+// FromDiff 生成把 from 变为 to 的操作
+func FromDiff(from, to string) *Operation {
+	op := &Operation{}
+	for _, chunk := range utils.DiffWords(from, to) {
+		n := len(utf16.Encode([]rune(chunk.Text)))
+		switch chunk.Op {
+		case utils.DiffEqual:
+			op.Retain(n)
+		case utils.DiffDelete:
+			op.Delete(n)
+		case utils.DiffInsert:
+			op.Insert(chunk.Text)
+		}
+	}
+	return op
+}
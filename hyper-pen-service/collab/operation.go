@@ -0,0 +1,260 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf16"
+)
+
+// 操作格式与 ot.js 的 TextOperation 相同：正整数表示保留，负整数表示删除，字符串表示插入。
+// 长度和位置均以 UTF-16 码元计算，与浏览器中 JavaScript 字符串的下标一致。
+
+var (
+	ErrBaseLength = errors.New("operation base length does not match document length")
+	ErrTransform  = errors.New("operations cannot be transformed")
+	ErrOperation  = errors.New("invalid operation")
+)
+
+// component 操作中的一个片段，Retain、Delete、Insert 三者只有一个有效
+type component struct {
+	Retain int
+	Delete int
+	Insert []uint16
+}
+
+// Operation 对文本的一次编辑
+type Operation struct {
+	components   []component
+	baseLength   int
+	targetLength int
+}
+
+// BaseLength 操作作用的文档长度
+func (o *Operation) BaseLength() int {
+	return o.baseLength
+}
+
+// TargetLength 操作作用后的文档长度
+func (o *Operation) TargetLength() int {
+	return o.targetLength
+}
+
+// IsNoop 操作是否不改变文档
+func (o *Operation) IsNoop() bool {
+	return len(o.components) == 0 || (len(o.components) == 1 && o.components[0].Retain > 0)
+}
+
+// Retain 保留 n 个码元
+func (o *Operation) Retain(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLength += n
+	o.targetLength += n
+	if last := o.last(); last != nil && last.Retain > 0 {
+		last.Retain += n
+		return o
+	}
+	o.components = append(o.components, component{Retain: n})
+	return o
+}
+
+// Insert 插入文本
+func (o *Operation) Insert(text string) *Operation {
+	return o.insertUnits(utf16.Encode([]rune(text)))
+}
+
+func (o *Operation) insertUnits(units []uint16) *Operation {
+	if len(units) == 0 {
+		return o
+	}
+	o.targetLength += len(units)
+
+	last := o.last()
+	if last != nil && last.Insert != nil {
+		last.Insert = append(last.Insert, units...)
+		return o
+	}
+	// 插入总是放在相邻的删除之前，保证同一编辑的表示唯一
+	if last != nil && last.Delete > 0 {
+		n := len(o.components)
+		if n > 1 && o.components[n-2].Insert != nil {
+			o.components[n-2].Insert = append(o.components[n-2].Insert, units...)
+			return o
+		}
+		o.components = append(o.components, o.components[n-1])
+		o.components[n-1] = component{Insert: append([]uint16(nil), units...)}
+		return o
+	}
+	o.components = append(o.components, component{Insert: append([]uint16(nil), units...)})
+	return o
+}
+
+// Delete 删除 n 个码元
+func (o *Operation) Delete(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLength += n
+	if last := o.last(); last != nil && last.Delete > 0 {
+		last.Delete += n
+		return o
+	}
+	o.components = append(o.components, component{Delete: n})
+	return o
+}
+
+func (o *Operation) last() *component {
+	if len(o.components) == 0 {
+		return nil
+	}
+	return &o.components[len(o.components)-1]
+}
+
+// Apply 将操作应用到文档上
+func (o *Operation) Apply(doc string) (string, error) {
+	units := utf16.Encode([]rune(doc))
+	if len(units) != o.baseLength {
+		return "", ErrBaseLength
+	}
+
+	result := make([]uint16, 0, o.targetLength)
+	pos := 0
+	for _, c := range o.components {
+		switch {
+		case c.Retain > 0:
+			result = append(result, units[pos:pos+c.Retain]...)
+			pos += c.Retain
+		case c.Delete > 0:
+			pos += c.Delete
+		default:
+			result = append(result, c.Insert...)
+		}
+	}
+	return string(utf16.Decode(result)), nil
+}
+
+// Transform 变换两个作用于同一文档的并发操作，返回 a' 和 b'，满足 apply(apply(S, a), b') = apply(apply(S, b), a')
+func Transform(a, b *Operation) (*Operation, *Operation, error) {
+	if a.baseLength != b.baseLength {
+		return nil, nil, ErrTransform
+	}
+
+	aPrime, bPrime := &Operation{}, &Operation{}
+	as, bs := a.components, b.components
+	i, j := 0, 0
+	var ca, cb *component
+	next := func(list []component, idx *int) *component {
+		if *idx >= len(list) {
+			return nil
+		}
+		c := list[*idx]
+		*idx++
+		return &c
+	}
+	ca, cb = next(as, &i), next(bs, &j)
+
+	for ca != nil || cb != nil {
+		if ca != nil && ca.Insert != nil {
+			aPrime.insertUnits(ca.Insert)
+			bPrime.Retain(len(ca.Insert))
+			ca = next(as, &i)
+			continue
+		}
+		if cb != nil && cb.Insert != nil {
+			aPrime.Retain(len(cb.Insert))
+			bPrime.insertUnits(cb.Insert)
+			cb = next(bs, &j)
+			continue
+		}
+		if ca == nil || cb == nil {
+			return nil, nil, ErrTransform
+		}
+
+		switch {
+		case ca.Retain > 0 && cb.Retain > 0:
+			n := min(ca.Retain, cb.Retain)
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+			ca.Retain -= n
+			cb.Retain -= n
+		case ca.Delete > 0 && cb.Delete > 0:
+			// 双方删除了相同的内容
+			n := min(ca.Delete, cb.Delete)
+			ca.Delete -= n
+			cb.Delete -= n
+		case ca.Delete > 0 && cb.Retain > 0:
+			n := min(ca.Delete, cb.Retain)
+			aPrime.Delete(n)
+			ca.Delete -= n
+			cb.Retain -= n
+		case ca.Retain > 0 && cb.Delete > 0:
+			n := min(ca.Retain, cb.Delete)
+			bPrime.Delete(n)
+			ca.Retain -= n
+			cb.Delete -= n
+		default:
+			return nil, nil, ErrTransform
+		}
+
+		if ca.Retain == 0 && ca.Delete == 0 {
+			ca = next(as, &i)
+		}
+		if cb.Retain == 0 && cb.Delete == 0 {
+			cb = next(bs, &j)
+		}
+	}
+
+	return aPrime, bPrime, nil
+}
+
+// MarshalJSON 输出 ot.js 格式
+func (o *Operation) MarshalJSON() ([]byte, error) {
+	items := make([]interface{}, 0, len(o.components))
+	for _, c := range o.components {
+		switch {
+		case c.Retain > 0:
+			items = append(items, c.Retain)
+		case c.Delete > 0:
+			items = append(items, -c.Delete)
+		default:
+			items = append(items, string(utf16.Decode(c.Insert)))
+		}
+	}
+	return json.Marshal(items)
+}
+
+// UnmarshalJSON 解析 ot.js 格式
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return ErrOperation
+	}
+
+	*o = Operation{}
+	for _, item := range items {
+		var text string
+		if err := json.Unmarshal(item, &text); err == nil {
+			o.Insert(text)
+			continue
+		}
+
+		var n int
+		if err := json.Unmarshal(item, &n); err != nil || n == 0 {
+			return ErrOperation
+		}
+		if n > 0 {
+			o.Retain(n)
+		} else {
+			o.Delete(-n)
+		}
+	}
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,243 @@
+package collab
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"unicode/utf16"
+)
+
+// op 从 ot.js 格式构造操作
+func op(t *testing.T, raw string) *Operation {
+	t.Helper()
+	var o Operation
+	if err := json.Unmarshal([]byte(raw), &o); err != nil {
+		t.Fatalf("unmarshal %s: %v", raw, err)
+	}
+	return &o
+}
+
+func TestOperationApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		op      string
+		want    string
+		wantErr error
+	}{
+		{name: "insert at start", doc: "world", op: `["hello ",5]`, want: "hello world"},
+		{name: "insert at end", doc: "hello", op: `[5," world"]`, want: "hello world"},
+		{name: "delete middle", doc: "hello cruel world", op: `[6,-6,5]`, want: "hello world"},
+		{name: "replace", doc: "abc", op: `[1,"X",-1,1]`, want: "aXc"},
+		{name: "empty document", doc: "", op: `["new"]`, want: "new"},
+		{name: "delete everything", doc: "gone", op: `[-4]`, want: ""},
+		{name: "chinese counts one unit per character", doc: "你好世界", op: `[2,"，",2]`, want: "你好，世界"},
+		{name: "emoji counts two units", doc: "a😀b", op: `[3,"!",1]`, want: "a😀!b"},
+		{name: "delete surrogate pair", doc: "a😀b", op: `[1,-2,1]`, want: "ab"},
+		{name: "insert emoji", doc: "ab", op: `[1,"👍🏽",1]`, want: "a👍🏽b"},
+		{name: "emoji base length mismatch", doc: "a😀b", op: `[3]`, wantErr: ErrBaseLength},
+		{name: "base length too long", doc: "abc", op: `[4]`, wantErr: ErrBaseLength},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := op(t, tt.op).Apply(tt.doc)
+			if err != tt.wantErr {
+				t.Fatalf("Apply error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Apply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperationLengths(t *testing.T) {
+	tests := []struct {
+		op     string
+		base   int
+		target int
+		noop   bool
+	}{
+		{op: `[]`, base: 0, target: 0, noop: true},
+		{op: `[5]`, base: 5, target: 5, noop: true},
+		{op: `[2,"😀",-1]`, base: 3, target: 4},
+		{op: `["中文"]`, base: 0, target: 2},
+	}
+
+	for _, tt := range tests {
+		o := op(t, tt.op)
+		if o.BaseLength() != tt.base || o.TargetLength() != tt.target || o.IsNoop() != tt.noop {
+			t.Errorf("%s: base=%d target=%d noop=%v, want base=%d target=%d noop=%v",
+				tt.op, o.BaseLength(), o.TargetLength(), o.IsNoop(), tt.base, tt.target, tt.noop)
+		}
+	}
+}
+
+func TestOperationJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "round trip", in: `[3,"x",-2,1]`, want: `[3,"x",-2,1]`},
+		{name: "merges adjacent components", in: `[1,2,"a","b",-1,-1]`, want: `[3,"ab",-2]`},
+		{name: "insert moves before delete", in: `[1,-2,"x",1]`, want: `[1,"x",-2,1]`},
+		{name: "surrogate pair survives", in: `["😀"]`, want: `["😀"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(op(t, tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("marshal = %s, want %s", data, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{`{}`, `[0]`, `[1.5]`, `[true]`, `"text"`} {
+		var o Operation
+		if err := json.Unmarshal([]byte(bad), &o); err != ErrOperation {
+			t.Errorf("unmarshal %s error = %v, want ErrOperation", bad, err)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		a    string
+		b    string
+		want string
+	}{
+		{name: "inserts at different positions", doc: "abc", a: `["x",3]`, b: `[3,"y"]`, want: "xabcy"},
+		{name: "inserts at same position keep a first", doc: "abc", a: `[1,"x",2]`, b: `[1,"y",2]`, want: "axybc"},
+		{name: "insert inside deleted range", doc: "abcdef", a: `[1,-4,1]`, b: `[3,"X",3]`, want: "aXf"},
+		{name: "overlapping deletes", doc: "abcdef", a: `[1,-3,2]`, b: `[2,-3,1]`, want: "af"},
+		{name: "same delete", doc: "abcdef", a: `[2,-2,2]`, b: `[2,-2,2]`, want: "abef"},
+		{name: "delete and retain", doc: "hello world", a: `[-6,5]`, b: `[11,"!"]`, want: "world!"},
+		{name: "noop against edit", doc: "abc", a: `[3]`, b: `[-1,"z",2]`, want: "zbc"},
+		{name: "empty document", doc: "", a: `["left"]`, b: `["right"]`, want: "leftright"},
+		{name: "emoji retained as two units", doc: "😀😀", a: `[2,"a",2]`, b: `[-2,2]`, want: "a😀"},
+		{name: "concurrent emoji inserts", doc: "ab", a: `[1,"😀",1]`, b: `[1,"👍",-1]`, want: "a😀👍"},
+		{name: "delete surrogate pair against insert after it", doc: "x😀y", a: `[1,-2,1]`, b: `[3,"!",1]`, want: "x!y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := op(t, tt.a), op(t, tt.b)
+			aPrime, bPrime, err := Transform(a, b)
+			if err != nil {
+				t.Fatalf("Transform: %v", err)
+			}
+
+			// TP1: apply(apply(S, a), b') == apply(apply(S, b), a')
+			afterA, err := a.Apply(tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			left, err := bPrime.Apply(afterA)
+			if err != nil {
+				t.Fatalf("apply b' after a: %v", err)
+			}
+			afterB, err := b.Apply(tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			right, err := aPrime.Apply(afterB)
+			if err != nil {
+				t.Fatalf("apply a' after b: %v", err)
+			}
+
+			if left != right {
+				t.Errorf("TP1 violated: a then b' = %q, b then a' = %q", left, right)
+			}
+			if left != tt.want {
+				t.Errorf("result = %q, want %q", left, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformBaseLengthMismatch(t *testing.T) {
+	if _, _, err := Transform(op(t, `[3]`), op(t, `[4]`)); err != ErrTransform {
+		t.Errorf("Transform error = %v, want ErrTransform", err)
+	}
+}
+
+func TestFromDiff(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+	}{
+		{from: "", to: "new"},
+		{from: "old", to: ""},
+		{from: "hello world", to: "hello there world"},
+		{from: "a😀b", to: "a👍b"},
+		{from: "😀", to: "😃"},
+		{from: "第一行\n第二行", to: "第一行\n第三行\n"},
+	}
+
+	for _, tt := range tests {
+		o := FromDiff(tt.from, tt.to)
+		got, err := o.Apply(tt.from)
+		if err != nil {
+			t.Errorf("FromDiff(%q, %q).Apply: %v", tt.from, tt.to, err)
+			continue
+		}
+		if got != tt.to {
+			t.Errorf("FromDiff(%q, %q) applied = %q", tt.from, tt.to, got)
+		}
+	}
+}
+
+// randomOperation 生成作用于 doc 的随机操作，插入内容包含代理对
+func randomOperation(r *rand.Rand, doc string) *Operation {
+	alphabet := []string{"a", "b", "中", "😀", "\n"}
+	o := &Operation{}
+	remaining := len(utf16.Encode([]rune(doc)))
+	for remaining > 0 {
+		n := r.Intn(remaining) + 1
+		switch r.Intn(3) {
+		case 0:
+			o.Retain(n)
+			remaining -= n
+		case 1:
+			o.Delete(n)
+			remaining -= n
+		default:
+			o.Insert(alphabet[r.Intn(len(alphabet))])
+		}
+	}
+	if r.Intn(2) == 0 {
+		o.Insert(alphabet[r.Intn(len(alphabet))])
+	}
+	return o
+}
+
+func TestTransformRandomTP1(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	docs := []string{"", "abc", "你好，世界", "a😀b😃c", "line1\nline2\n"}
+	for i := 0; i < 2000; i++ {
+		doc := docs[i%len(docs)]
+		a, b := randomOperation(r, doc), randomOperation(r, doc)
+		aPrime, bPrime, err := Transform(a, b)
+		if err != nil {
+			t.Fatalf("Transform(%v, %v): %v", a, b, err)
+		}
+
+		afterA, _ := a.Apply(doc)
+		afterB, _ := b.Apply(doc)
+		left, errLeft := bPrime.Apply(afterA)
+		right, errRight := aPrime.Apply(afterB)
+		if errLeft != nil || errRight != nil || left != right {
+			aJSON, _ := json.Marshal(a)
+			bJSON, _ := json.Marshal(b)
+			t.Fatalf("TP1 violated on %q with a=%s b=%s: %q (%v) vs %q (%v)", doc, aJSON, bJSON, left, errLeft, right, errRight)
+		}
+	}
+}
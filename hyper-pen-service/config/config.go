@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 )
 
 type Config struct {
@@ -12,19 +13,49 @@ type Config struct {
 	WechatAppID        string
 	WechatAppSecret    string
 	WechatRedirectURI  string
+	// 第三方登录回调后跳转回的前端页面，带一次性授权码 code 或错误信息 error
+	OAuthUIRedirectURL string
+	// OIDC 提供方名称，用于登录路由和绑定关系
+	OIDCName string
+	// OIDC issuer 地址，为空时不启用 OIDC 登录
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURI  string
+	// OIDC 授权范围，以空格分隔
+	OIDCScopes string
+	// 回收站中的笔记保留天数，0 表示不自动清理
+	TrashRetentionDays int
+	// 附件在本地文件系统中的存储目录
+	AttachmentDir string
+	// 单个附件的大小上限（MB）
+	MaxAttachmentSizeMB int
+	// 最多缓存渲染结果的笔记数量，0 表示不缓存
+	RenderCacheSize int
 }
 
 var AppConfig Config
 
 func LoadConfig() {
 	AppConfig = Config{
-		GitHubClientID:     getEnv("GITHUB_CLIENT_ID", ""),
-		GitHubClientSecret: getEnv("GITHUB_CLIENT_SECRET", ""),
-		GitHubRedirectURI:  getEnv("GITHUB_REDIRECT_URI", "http://localhost:3000/auth/github/callback"),
-		JWTSecret:          getEnv("JWT_SECRET", "your-secret-key"),
-		WechatAppID:        getEnv("WECHAT_APP_ID", ""),
-		WechatAppSecret:    getEnv("WECHAT_APP_SECRET", ""),
-		WechatRedirectURI:  getEnv("WECHAT_REDIRECT_URI", "http://localhost:3000/auth/wechat/callback"),
+		GitHubClientID:      getEnv("GITHUB_CLIENT_ID", ""),
+		GitHubClientSecret:  getEnv("GITHUB_CLIENT_SECRET", ""),
+		GitHubRedirectURI:   getEnv("GITHUB_REDIRECT_URI", "http://localhost:3000/api/auth/github/callback"),
+		JWTSecret:           getEnv("JWT_SECRET", "your-secret-key"),
+		WechatAppID:         getEnv("WECHAT_APP_ID", ""),
+		WechatAppSecret:     getEnv("WECHAT_APP_SECRET", ""),
+		WechatRedirectURI:   getEnv("WECHAT_REDIRECT_URI", "http://localhost:3000/api/auth/wechat/callback"),
+		OAuthUIRedirectURL:  getEnv("OAUTH_UI_REDIRECT_URL", "http://localhost:3000/auth/callback"),
+		OIDCName:            getEnv("OIDC_NAME", "oidc"),
+		OIDCIssuerURL:       getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:        getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:    getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURI:     getEnv("OIDC_REDIRECT_URI", "http://localhost:3000/api/auth/oidc/callback"),
+		OIDCScopes:          getEnv("OIDC_SCOPES", "openid profile email"),
+		TrashRetentionDays:  getEnvInt("TRASH_RETENTION_DAYS", 30),
+		AttachmentDir:       getEnv("ATTACHMENT_DIR", "attachments"),
+		MaxAttachmentSizeMB: getEnvInt("MAX_ATTACHMENT_SIZE_MB", 20),
+		RenderCacheSize:     getEnvInt("RENDER_CACHE_SIZE", 1000),
 	}
 }
 
@@ -35,3 +66,11 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"hyper-pen-service/models"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// AccountHandler 处理当前用户帐户设置相关的请求
+type AccountHandler struct {
+	db *gorm.DB
+}
+
+// NewAccountHandler 创建新的帐户处理器
+func NewAccountHandler(db *gorm.DB) *AccountHandler {
+	return &AccountHandler{db: db}
+}
+
+// RevisionRetentionRequest 修订版本保留策略，0 表示不限制
+type RevisionRetentionRequest struct {
+	KeepCount int `json:"keep_count"`
+	KeepDays  int `json:"keep_days"`
+}
+
+// GetRevisionRetention 获取修订版本保留策略
+func (h *AccountHandler) GetRevisionRetention(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "用户不存在"})
+		return
+	}
+
+	ctx.JSON(RevisionRetentionRequest{
+		KeepCount: user.RevisionKeepCount,
+		KeepDays:  user.RevisionKeepDays,
+	})
+}
+
+// UpdateRevisionRetention 更新修订版本保留策略，并立即按新策略清理旧版本
+func (h *AccountHandler) UpdateRevisionRetention(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	var req RevisionRetentionRequest
+	if err := ctx.ReadJSON(&req); err != nil || req.KeepCount < 0 || req.KeepDays < 0 {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "无效的请求数据"})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"revision_keep_count": req.KeepCount,
+			"revision_keep_days":  req.KeepDays,
+		}).Error; err != nil {
+			return err
+		}
+		return pruneRevisions(tx, userID, "")
+	})
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "更新保留策略失败"})
+		return
+	}
+
+	ctx.JSON(req)
+}
+
+// errLastLoginMethod 解绑后帐户将无法登录
+var errLastLoginMethod = errors.New("不能解绑唯一的登录方式，请先绑定其他帐户")
+
+// GetIdentities 获取当前用户绑定的第三方帐户，has_password 表示是否可以使用密码登录
+func (h *AccountHandler) GetIdentities(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "用户不存在"})
+		return
+	}
+
+	var identities []models.Identity
+	if err := h.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取绑定帐户失败"})
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"has_password": user.Password != "",
+		"identities":   identities,
+	})
+}
+
+// UnlinkIdentity 解绑第三方帐户，没有设置密码且这是唯一绑定的帐户时拒绝解绑
+func (h *AccountHandler) UnlinkIdentity(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+	provider := ctx.Params().Get("provider")
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		var identity models.Identity
+		if err := tx.Where("user_id = ? AND provider = ?", userID, provider).First(&identity).Error; err != nil {
+			return err
+		}
+
+		var others int64
+		if err := tx.Model(&models.Identity{}).Where("user_id = ? AND id <> ?", userID, identity.ID).
+			Count(&others).Error; err != nil {
+			return err
+		}
+		if user.Password == "" && others == 0 {
+			return errLastLoginMethod
+		}
+
+		if err := tx.Delete(&identity).Error; err != nil {
+			return err
+		}
+		if provider == models.ProviderGitHub {
+			return tx.Model(&user).Update("git_hub_token", "").Error
+		}
+		return nil
+	})
+	switch {
+	case err == nil:
+		ctx.StatusCode(iris.StatusNoContent)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "未绑定该帐户"})
+	case errors.Is(err, errLastLoginMethod):
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(iris.Map{"error": err.Error()})
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "解绑帐户失败"})
+	}
+}
@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hyper-pen-service/config"
+	"hyper-pen-service/models"
+	"hyper-pen-service/storage"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// inlineContentTypes 可以在浏览器中直接显示的类型，其他类型一律作为下载返回，避免上传的 HTML/SVG 在本站执行脚本
+var inlineContentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// multipartOverhead 上传请求中文件内容之外的表单开销上限
+const multipartOverhead = 1 << 20
+
+// AttachmentHandler 处理笔记附件相关的请求
+type AttachmentHandler struct {
+	db      *gorm.DB
+	store   storage.Storage
+	maxSize int64
+	// mu 串行化内容的写入和回收，避免删除正在被新附件引用的内容
+	mu sync.Mutex
+}
+
+// NewAttachmentHandler 创建新的附件处理器，maxSize 为单个文件的大小上限（字节）
+func NewAttachmentHandler(db *gorm.DB, store storage.Storage, maxSize int64) *AttachmentHandler {
+	return &AttachmentHandler{db: db, store: store, maxSize: maxSize}
+}
+
+// AttachmentResponse 附件信息及其下载地址
+type AttachmentResponse struct {
+	models.Attachment
+	URL string `json:"url"`
+}
+
+func newAttachmentResponse(attachment models.Attachment) AttachmentResponse {
+	return AttachmentResponse{
+		Attachment: attachment,
+		URL:        fmt.Sprintf("/api/notes/%s/attachments/%s", attachment.NoteID, attachment.ID),
+	}
+}
+
+// findNote 查找未删除的笔记并检查当前用户至少具有 minRole 权限
+func (h *AttachmentHandler) findNote(ctx iris.Context, minRole string) (*models.Note, bool) {
+	id := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	note, err := accessibleNote(h.db.Select("id", "user_id", "category_id"), userID, id, minRole)
+	if err != nil {
+		writeNoteAccessError(ctx, err)
+		return nil, false
+	}
+	return note, true
+}
+
+// UploadAttachments 上传附件，multipart 字段 file 可以包含多个文件，需要编辑权限
+func (h *AttachmentHandler) UploadAttachments(ctx iris.Context) {
+	note, ok := h.findNote(ctx, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	// 一次上传的所有文件合计不超过单个文件的大小上限，另外留出表单本身的开销
+	ctx.Request().Body = http.MaxBytesReader(ctx.ResponseWriter(), ctx.Request().Body, h.maxSize+multipartOverhead)
+	if err := ctx.Request().ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+			ctx.JSON(iris.Map{"error": fmt.Sprintf("Upload exceeds the %d MB limit", h.maxSize>>20)})
+			return
+		}
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Invalid multipart form"})
+		return
+	}
+	defer ctx.Request().MultipartForm.RemoveAll()
+
+	headers := ctx.Request().MultipartForm.File["file"]
+	if len(headers) == 0 {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "No file uploaded"})
+		return
+	}
+	for _, header := range headers {
+		if header.Size > h.maxSize {
+			ctx.StatusCode(iris.StatusRequestEntityTooLarge)
+			ctx.JSON(iris.Map{"error": fmt.Sprintf("File %s exceeds the %d MB limit", header.Filename, h.maxSize>>20)})
+			return
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	attachments := make([]models.Attachment, 0, len(headers))
+	// written 本次新写入存储的内容，保存失败时删除，避免留下没有附件引用的内容
+	var written []string
+	for _, header := range headers {
+		attachment, created, err := h.storeFile(note, header)
+		if err != nil {
+			log.Printf("保存附件失败: %v", err)
+			h.deleteContents(written)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "Failed to store file " + header.Filename})
+			return
+		}
+		if created {
+			written = append(written, attachment.Hash)
+		}
+		attachments = append(attachments, *attachment)
+	}
+
+	if err := h.db.Create(&attachments).Error; err != nil {
+		h.deleteContents(written)
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to save attachments"})
+		return
+	}
+
+	response := make([]AttachmentResponse, 0, len(attachments))
+	for _, attachment := range attachments {
+		response = append(response, newAttachmentResponse(attachment))
+	}
+	ctx.StatusCode(iris.StatusCreated)
+	ctx.JSON(iris.Map{
+		"message":     "Attachments uploaded successfully",
+		"attachments": response,
+	})
+}
+
+// storeFile 计算文件哈希并写入存储，内容已存在时不会重复保存，返回的布尔值表示内容是否为本次新写入
+func (h *AttachmentHandler) storeFile(note *models.Note, header *multipart.FileHeader) (*models.Attachment, bool, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	hash, size, err := storage.Hash(file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	contentType := detectContentType(header.Filename, file)
+
+	// 写入存储是最后一步，返回错误时不会留下新写入的内容
+	exists, err := h.store.Exists(hash)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, false, err
+		}
+		if err := h.store.Put(hash, file); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return &models.Attachment{
+		ID:          uuid.New().String(),
+		UserID:      note.UserID,
+		NoteID:      note.ID,
+		FileName:    filepath.Base(header.Filename),
+		ContentType: contentType,
+		Size:        size,
+		Hash:        hash,
+	}, !exists, nil
+}
+
+// deleteContents 删除上传失败时新写入的内容，调用方需持有 mu，此时这些内容不会被其他附件引用
+func (h *AttachmentHandler) deleteContents(hashes []string) {
+	for _, hash := range hashes {
+		if err := h.store.Delete(hash); err != nil {
+			log.Printf("删除附件内容失败 %s: %v", hash, err)
+		}
+	}
+}
+
+// detectContentType 优先按扩展名判断类型，无法判断时嗅探文件内容
+func detectContentType(name string, r io.Reader) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(name))); contentType != "" {
+		return contentType
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(r, head)
+	return http.DetectContentType(head[:n])
+}
+
+// GetAttachments 获取笔记的附件列表
+func (h *AttachmentHandler) GetAttachments(ctx iris.Context) {
+	note, ok := h.findNote(ctx, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	var attachments []models.Attachment
+	if err := h.db.Where("note_id = ?", note.ID).Order("created_at").Find(&attachments).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch attachments"})
+		return
+	}
+
+	response := make([]AttachmentResponse, 0, len(attachments))
+	for _, attachment := range attachments {
+		response = append(response, newAttachmentResponse(attachment))
+	}
+	ctx.JSON(response)
+}
+
+// DownloadAttachment 下载附件。笔记所有者和协作者通过 Authorization 请求头或获取笔记时签发的 user 和 grant 查询参数访问，
+// 通过分享链接打开的笔记使用 share 查询参数携带分享 token
+func (h *AttachmentHandler) DownloadAttachment(ctx iris.Context) {
+	noteID := ctx.Params().Get("id")
+	attachmentID := ctx.Params().Get("attachmentId")
+
+	var attachment models.Attachment
+	if err := h.db.Where("id = ? AND note_id = ?", attachmentID, noteID).First(&attachment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.JSON(iris.Map{"error": "Attachment not found"})
+			return
+		}
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch attachment"})
+		return
+	}
+
+	allowed, err := h.canDownload(ctx, &attachment)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch attachment"})
+		return
+	}
+	if !allowed {
+		// 不区分无权访问和不存在，避免泄露附件ID是否有效
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "Attachment not found"})
+		return
+	}
+
+	file, err := h.store.Open(attachment.Hash)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.JSON(iris.Map{"error": "Attachment content is missing"})
+			return
+		}
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to open attachment"})
+		return
+	}
+	defer file.Close()
+
+	ctx.Header("Content-Type", attachment.ContentType)
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Header("Cache-Control", "private, max-age=86400")
+	ctx.Header("ETag", `"`+attachment.Hash+`"`)
+	if inlineContentTypes[attachment.ContentType] {
+		ctx.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.FileName}))
+	} else {
+		setAttachmentHeader(ctx, attachment.FileName)
+	}
+	http.ServeContent(ctx.ResponseWriter(), ctx.Request(), attachment.FileName, attachment.CreatedAt, file)
+}
+
+// canDownload 检查当前用户或凭证签发给的用户是否可以查看附件所在的笔记，或者请求携带了该笔记或其所在分类有效的分享 token。
+// 通过分享 token 下载时需要访问笔记时签发的凭证，或者满足访问分享链接本身的密码和访问次数限制
+func (h *AttachmentHandler) canDownload(ctx iris.Context, attachment *models.Attachment) (bool, error) {
+	userID, ok := ctx.Values().Get("userID").(uint)
+	if !ok {
+		// <img> 等请求无法携带 Authorization 请求头，使用获取笔记时签发的凭证
+		if id, err := strconv.ParseUint(ctx.URLParam("user"), 10, 64); err == nil &&
+			verifyAttachmentGrant(ctx.URLParam("grant"), userGrantSubject(uint(id)), attachment.NoteID) {
+			userID, ok = uint(id), true
+		}
+	}
+	if ok {
+		if userID == attachment.UserID {
+			return true, nil
+		}
+		// 凭证有效期内取消了协作者的权限时同样拒绝访问
+		_, err := accessibleNote(h.db.Select("id", "user_id", "category_id"), userID, attachment.NoteID, models.RoleViewer)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, err
+		}
+	}
+
+	token := ctx.URLParam("share")
+	if token == "" {
+		return false, nil
+	}
+	var shareLink models.ShareLink
+	if err := h.db.Where("token = ? AND expires_at > ?", token, time.Now()).First(&shareLink).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	// 所有者调低访问次数上限后，之前签发的凭证也随之失效
+	if shareLink.MaxViews > 0 && shareLink.ViewCount > shareLink.MaxViews {
+		return false, nil
+	}
+	// 没有访问笔记时签发的凭证，按访问分享链接的规则检查访问次数和密码
+	if !verifyAttachmentGrant(ctx.URLParam("grant"), token, attachment.NoteID) {
+		if shareLink.MaxViews > 0 && shareLink.ViewCount >= shareLink.MaxViews {
+			return false, nil
+		}
+		if shareLink.PasswordHash != "" {
+			password := ctx.GetHeader(sharePasswordHeader)
+			if password == "" || bcrypt.CompareHashAndPassword([]byte(shareLink.PasswordHash), []byte(password)) != nil {
+				return false, nil
+			}
+		}
+	}
+
+	query := h.db.Model(&models.Note{}).Where("notes.id = ?", attachment.NoteID)
+	if shareLink.CategoryID != "" {
+		query = sharedCategoryNotes(query, shareLink.CategoryID)
+	} else if shareLink.NoteID != attachment.NoteID {
+		return false, nil
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
+// DeleteAttachment 删除附件，内容不再被任何附件引用时从存储中删除，需要编辑权限
+func (h *AttachmentHandler) DeleteAttachment(ctx iris.Context) {
+	note, ok := h.findNote(ctx, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var attachment models.Attachment
+	if err := h.db.Where("id = ? AND note_id = ?", ctx.Params().Get("attachmentId"), note.ID).First(&attachment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.JSON(iris.Map{"error": "Attachment not found"})
+			return
+		}
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch attachment"})
+		return
+	}
+
+	if err := h.db.Delete(&attachment).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to delete attachment"})
+		return
+	}
+	h.RemoveUnreferenced([]string{attachment.Hash})
+
+	ctx.JSON(iris.Map{"message": "Attachment deleted successfully"})
+}
+
+// RemoveUnreferenced 从存储中删除不再被任何附件引用的内容
+func (h *AttachmentHandler) RemoveUnreferenced(hashes []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, hash := range hashes {
+		var count int64
+		if err := h.db.Model(&models.Attachment{}).Where("hash = ?", hash).Count(&count).Error; err != nil {
+			log.Printf("检查附件引用失败 %s: %v", hash, err)
+			continue
+		}
+		if count > 0 {
+			continue
+		}
+		if err := h.store.Delete(hash); err != nil {
+			log.Printf("删除附件内容失败 %s: %v", hash, err)
+		}
+	}
+}
+
+// attachmentGrantTTL 获取笔记时为附件地址签发的凭证的有效期
+const attachmentGrantTTL = time.Hour
+
+// shareAttachmentURLs 为分享的笔记正文中引用的本笔记附件地址加上分享 token 和短期凭证，
+// 使匿名访问者不必再次提供密码或计入访问次数即可加载图片
+func shareAttachmentURLs(content, noteID, token string) string {
+	grant := signAttachmentGrant(token, noteID, time.Now().Add(attachmentGrantTTL))
+	return attachmentURLsWithQuery(content, noteID, "share="+url.QueryEscape(token)+"&grant="+url.QueryEscape(grant))
+}
+
+// userAttachmentQuery 为笔记所有者或协作者签发下载本笔记附件的查询参数
+func userAttachmentQuery(noteID string, userID uint) string {
+	grant := signAttachmentGrant(userGrantSubject(userID), noteID, time.Now().Add(attachmentGrantTTL))
+	return "user=" + strconv.FormatUint(uint64(userID), 10) + "&grant=" + url.QueryEscape(grant)
+}
+
+// userGrantSubject 用户凭证的签名对象，与分享 token 区分开
+func userGrantSubject(userID uint) string {
+	return "user:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// attachmentURLsWithQuery 为正文中引用的本笔记附件地址加上查询参数
+func attachmentURLsWithQuery(content, noteID, query string) string {
+	pattern := regexp.MustCompile(`/api/notes/` + regexp.QuoteMeta(noteID) + `/attachments/[0-9a-fA-F-]{36}`)
+	return pattern.ReplaceAllString(content, "${0}?"+query)
+}
+
+// signAttachmentGrant 使用 JWT 密钥签发下载笔记附件的凭证，subject 为分享 token 或用户，格式为 过期时间.签名
+func signAttachmentGrant(token, noteID string, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expires + "." + attachmentGrantMAC(token, noteID, expires)
+}
+
+// verifyAttachmentGrant 检查凭证是否为该分享链接或用户和笔记签发且未过期
+func verifyAttachmentGrant(grant, token, noteID string) bool {
+	expires, mac, ok := strings.Cut(grant, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(attachmentGrantMAC(token, noteID, expires)))
+}
+
+func attachmentGrantMAC(token, noteID, expires string) string {
+	mac := hmac.New(sha256.New, []byte(config.AppConfig.JWTSecret))
+	mac.Write([]byte("attachment\n" + token + "\n" + noteID + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
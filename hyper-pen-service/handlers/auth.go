@@ -1,15 +1,16 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"errors"
 	"hyper-pen-service/config"
 	"hyper-pen-service/models"
+	"hyper-pen-service/oauth"
 	"hyper-pen-service/utils"
-	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 
 	"github.com/kataras/iris/v12"
@@ -34,11 +35,24 @@ type LoginResponse struct {
 }
 
 type AuthHandler struct {
-	db *gorm.DB
+	db        *gorm.DB
+	logins    *loginSessionStore
+	exchanges *exchangeCodeStore
+	providers map[string]oauth.IdentityProvider
 }
 
-func NewAuthHandler(db *gorm.DB) *AuthHandler {
-	return &AuthHandler{db: db}
+// NewAuthHandler 创建认证处理器，providers 为启用的第三方身份提供方，按名称注册路由
+func NewAuthHandler(db *gorm.DB, providers ...oauth.IdentityProvider) *AuthHandler {
+	h := &AuthHandler{
+		db:        db,
+		logins:    newLoginSessionStore(),
+		exchanges: newExchangeCodeStore(),
+		providers: make(map[string]oauth.IdentityProvider),
+	}
+	for _, p := range providers {
+		h.providers[p.Name()] = p
+	}
+	return h
 }
 
 // Login 处理用户登录
@@ -98,9 +112,13 @@ func (h *AuthHandler) Register(ctx iris.Context) {
 		Email:    req.Email,
 	}
 
-	if err := h.db.Create(&user).Error; err != nil {
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": "Failed to create user"})
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkUserAvailable(tx, user.Username, user.Email); err != nil {
+			return err
+		}
+		return tx.Create(&user).Error
+	}); err != nil {
+		writeRegisterError(ctx, err)
 		return
 	}
 
@@ -117,302 +135,209 @@ func (h *AuthHandler) Register(ctx iris.Context) {
 	})
 }
 
-// GitHubOAuthLogin 处理GitHub OAuth登录请求
-func (h *AuthHandler) GitHubOAuthLogin(ctx iris.Context) {
-	w := ctx.ResponseWriter()
-	r := ctx.Request()
-	// 构建GitHub OAuth授权URL
-	authURL := "https://github.com/login/oauth/authorize"
-	params := url.Values{}
-	params.Add("client_id", config.AppConfig.GitHubClientID)
-	params.Add("redirect_uri", config.AppConfig.GitHubRedirectURI)
-	params.Add("scope", "user:email")
-
-	// 重定向到GitHub授权页面
-	http.Redirect(w, r, authURL+"?"+params.Encode(), http.StatusFound)
+// Providers 返回启用的第三方身份提供方名称，客户端据此显示登录按钮
+func (h *AuthHandler) Providers(ctx iris.Context) {
+	names := make([]string, 0, len(h.providers))
+	for name := range h.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ctx.JSON(iris.Map{"providers": names})
 }
 
-// GitHubOAuthCallback 处理GitHub OAuth回调
-func (h *AuthHandler) GitHubOAuthCallback(ctx iris.Context) {
-	w := ctx.ResponseWriter()
-	//r := ctx.Request()
-	code := ctx.URLParam("code")
-	if code == "" {
-		http.Error(w, "Authorization code not found", http.StatusBadRequest)
+// OAuthLogin 处理第三方登录请求，重定向到提供方的授权页面
+func (h *AuthHandler) OAuthLogin(ctx iris.Context) {
+	provider, ok := h.providers[ctx.Params().Get("provider")]
+	if !ok {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "Unsupported provider"})
 		return
 	}
 
-	// 获取访问令牌
-	accessToken, err := h.getGitHubAccessToken(code)
+	authURL, _, err := h.beginLogin(ctx, provider, 0, false)
 	if err != nil {
-		http.Error(w, "Failed to get access token", http.StatusInternalServerError)
-		return
-	}
-
-	// 获取用户信息
-	userInfo, err := h.getGitHubUserInfo(accessToken)
-	if err != nil {
-		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to start login"})
 		return
 	}
+	ctx.Redirect(authURL, iris.StatusFound)
+}
 
-	// 查找或创建用户
-	var user models.User
-	result := h.db.Where("github_id = ?", userInfo.ID).First(&user)
-	if result.Error == gorm.ErrRecordNotFound {
-		// 创建新用户
-		user = models.User{
-			Username:    userInfo.Login,
-			Email:       userInfo.Email,
-			GithubID:    userInfo.ID,
-			AvatarURL:   userInfo.AvatarURL,
-			GitHubToken: accessToken,
-		}
-		if err := h.db.Create(&user).Error; err != nil {
-			http.Error(w, "Failed to create user", http.StatusInternalServerError)
-			return
-		}
-	} else if result.Error != nil {
-		http.Error(w, "Failed to find user", http.StatusInternalServerError)
+// WechatQRLogin 创建扫码登录会话，返回二维码页面地址，浏览器随后通过 WechatLoginStatus 轮询登录结果
+func (h *AuthHandler) WechatQRLogin(ctx iris.Context) {
+	provider, ok := h.providers[models.ProviderWechat]
+	if !ok {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "Unsupported provider"})
 		return
-	} else {
-		// 更新现有用户信息
-		user.AvatarURL = userInfo.AvatarURL
-		user.GitHubToken = accessToken
-		if err := h.db.Save(&user).Error; err != nil {
-			http.Error(w, "Failed to update user", http.StatusInternalServerError)
-			return
-		}
 	}
 
-	// 生成JWT令牌
-	token, err := utils.GenerateToken(&user)
+	authURL, state, err := h.beginLogin(ctx, provider, 0, true)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to start login"})
 		return
 	}
-
-	// 返回成功响应
-	response := map[string]interface{}{
-		"token": token,
-		"user": map[string]interface{}{
-			"id":         user.ID,
-			"username":   user.Username,
-			"email":      user.Email,
-			"avatar_url": user.AvatarURL,
-		},
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	ctx.JSON(iris.Map{
+		"qrcode_url": authURL,
+		"state":      state,
+		"expires_in": int(loginSessionTTL.Seconds()),
+	})
 }
 
-// getGitHubAccessToken 获取GitHub访问令牌
-func (h *AuthHandler) getGitHubAccessToken(code string) (string, error) {
-	data := url.Values{}
-	data.Set("client_id", config.AppConfig.GitHubClientID)
-	data.Set("client_secret", config.AppConfig.GitHubClientSecret)
-	data.Set("code", code)
-	data.Set("redirect_uri", config.AppConfig.GitHubRedirectURI)
-
-	resp, err := http.Post("https://github.com/login/oauth/access_token",
-		"application/x-www-form-urlencoded",
-		strings.NewReader(data.Encode()))
+// beginLogin 生成 state、nonce 和 PKCE 参数并创建等待回调的会话，state 同时写入 Cookie，
+// 回调时据此确认是发起登录的浏览器。linkUserID 非 0 时回调结果绑定到该用户，poll 为 true 时结果由浏览器轮询取走
+func (h *AuthHandler) beginLogin(ctx iris.Context, provider oauth.IdentityProvider, linkUserID uint, poll bool) (string, string, error) {
+	req, err := oauth.NewAuthRequest()
 	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+		return "", "", err
 	}
-
-	values, err := url.ParseQuery(string(body))
+	authURL, err := provider.AuthURL(req)
 	if err != nil {
-		return "", err
+		log.Printf("构建 %s 授权地址失败: %v", provider.Name(), err)
+		return "", "", err
 	}
+	expiresAt := h.logins.create(req, provider.Name(), linkUserID, poll)
 
-	return values.Get("access_token"), nil
-}
-
-// GitHubUserInfo GitHub用户信息结构
-type GitHubUserInfo struct {
-	ID        string `json:"id"`
-	Login     string `json:"login"`
-	Email     string `json:"email"`
-	AvatarURL string `json:"avatar_url"`
+	ctx.SetCookie(&http.Cookie{
+		Name:     loginStateCookie,
+		Value:    req.State,
+		Path:     "/",
+		Expires:  expiresAt,
+		MaxAge:   int(loginSessionTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return authURL, req.State, nil
 }
 
-// getGitHubUserInfo 获取GitHub用户信息
-func (h *AuthHandler) getGitHubUserInfo(accessToken string) (*GitHubUserInfo, error) {
-	req, err := http.NewRequest("GET", "https://api.github.com/user", nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var userInfo GitHubUserInfo
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		return nil, err
+// OAuthCallback 处理第三方回调，state 必须是同一提供方仍在等待回调的会话，处理后跳转回前端的回调页面。
+// 扫码登录的结果交给轮询的浏览器，跳转地址只带 status；其他会话要求回调来自发起登录的浏览器，
+// 结果换成一次性授权码，前端通过 ExchangeCode 取走令牌，令牌不会出现在地址栏和浏览历史中。
+// 失败时跳转地址带 error
+func (h *AuthHandler) OAuthCallback(ctx iris.Context) {
+	provider, ok := h.providers[ctx.Params().Get("provider")]
+	if !ok {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "Unsupported provider"})
+		return
 	}
-
-	return &userInfo, nil
-}
-
-// WechatLogin 处理微信登录请求
-func (h *AuthHandler) WechatLogin(ctx iris.Context) {
-	w := ctx.ResponseWriter()
-	r := ctx.Request()
-	// 生成随机state参数，用于防止CSRF攻击
-	state := generateRandomString(16)
-
-	// 构建微信授权URL
-	authURL := "https://open.weixin.qq.com/connect/qrconnect"
-	params := url.Values{}
-	params.Add("appid", config.AppConfig.WechatAppID)
-	params.Add("redirect_uri", config.AppConfig.WechatRedirectURI)
-	params.Add("response_type", "code")
-	params.Add("scope", "snsapi_login")
-	params.Add("state", state)
-
-	// 重定向到微信授权页面
-	http.Redirect(w, r, authURL+"?"+params.Encode(), http.StatusFound)
-}
-
-// WechatCallback 处理微信回调
-func (h *AuthHandler) WechatCallback(ctx iris.Context) {
-	w := ctx.ResponseWriter()
-	//r := ctx.Request()
 	code := ctx.URLParam("code")
 	if code == "" {
-		http.Error(w, "Authorization code not found", http.StatusBadRequest)
+		h.redirectToUI(ctx, url.Values{"error": {"Authorization code not found"}})
 		return
 	}
 
-	// 获取访问令牌
-	accessToken, openID, err := h.getWechatAccessToken(code)
-	if err != nil {
-		http.Error(w, "Failed to get access token", http.StatusInternalServerError)
+	state := ctx.URLParam("state")
+	session, ok := h.logins.scan(state, provider.Name())
+	if !ok || (!session.poll && ctx.GetCookie(loginStateCookie) != state) {
+		h.redirectToUI(ctx, url.Values{"error": {"Invalid or expired state"}})
 		return
 	}
+	if !session.poll {
+		// 不需要轮询，回调后会话即失效
+		defer h.logins.remove(state)
+	}
 
-	// 获取用户信息
-	userInfo, err := h.getWechatUserInfo(accessToken, openID)
+	response, err := h.completeLogin(ctx.Request().Context(), provider, code, session)
 	if err != nil {
-		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
+		h.logins.remove(state)
+		h.redirectToUI(ctx, url.Values{"error": {err.Error()}})
 		return
 	}
-
-	// 查找或创建用户
-	var user models.User
-	result := h.db.Where("wechat_id = ?", openID).First(&user)
-	if result.Error == gorm.ErrRecordNotFound {
-		// 创建新用户
-		user = models.User{
-			WechatID:  openID,
-			Username:  userInfo.Nickname,
-			AvatarURL: userInfo.HeadImgURL,
-		}
-		if err := h.db.Create(&user).Error; err != nil {
-			http.Error(w, "Failed to create user", http.StatusInternalServerError)
-			return
-		}
-	} else if result.Error != nil {
-		http.Error(w, "Failed to find user", http.StatusInternalServerError)
+	if session.poll {
+		h.logins.confirm(state, response)
+		h.redirectToUI(ctx, url.Values{"status": {loginConfirmed}})
 		return
 	}
 
-	// 生成JWT令牌
-	token, err := utils.GenerateToken(&user)
+	exchange, err := h.exchanges.issue(response)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		h.redirectToUI(ctx, url.Values{"error": {"Failed to complete login"}})
 		return
 	}
+	h.redirectToUI(ctx, url.Values{"code": {exchange}})
+}
 
-	// 返回成功响应
-	response := map[string]interface{}{
-		"token": token,
-		"user": map[string]interface{}{
-			"id":         user.ID,
-			"username":   user.Username,
-			"avatar_url": user.AvatarURL,
-		},
+// redirectToUI 跳转到前端的第三方登录回调页面
+func (h *AuthHandler) redirectToUI(ctx iris.Context, params url.Values) {
+	target := config.AppConfig.OAuthUIRedirectURL
+	sep := "?"
+	if strings.Contains(target, "?") {
+		sep = "&"
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	ctx.Redirect(target+sep+params.Encode(), iris.StatusFound)
 }
 
-// WechatUserInfo 微信用户信息结构
-type WechatUserInfo struct {
-	OpenID     string `json:"openid"`
-	Nickname   string `json:"nickname"`
-	HeadImgURL string `json:"headimgurl"`
+// ExchangeCodeRequest 兑换一次性授权码的请求
+type ExchangeCodeRequest struct {
+	Code string `json:"code"`
 }
 
-// generateRandomString 生成指定长度的随机字符串
-func generateRandomString(length int) string {
-	b := make([]byte, length)
-	if _, err := rand.Read(b); err != nil {
-		return ""
+// ExchangeCode 用第三方登录回调跳转时得到的一次性授权码换取结果：令牌和用户信息、
+// 首次登录时完成注册所需的受限令牌，或者绑定结果。授权码只能使用一次
+func (h *AuthHandler) ExchangeCode(ctx iris.Context) {
+	var req ExchangeCodeRequest
+	if err := ctx.ReadJSON(&req); err != nil || req.Code == "" {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Exchange code is required"})
+		return
+	}
+
+	response, ok := h.exchanges.redeem(req.Code)
+	if !ok {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Invalid or expired exchange code"})
+		return
 	}
-	return base64.URLEncoding.EncodeToString(b)[:length]
+	ctx.JSON(response)
 }
 
-// getWechatAccessToken 获取微信访问令牌
-func (h *AuthHandler) getWechatAccessToken(code string) (string, string, error) {
-	params := url.Values{}
-	params.Add("appid", config.AppConfig.WechatAppID)
-	params.Add("secret", config.AppConfig.WechatAppSecret)
-	params.Add("code", code)
-	params.Add("grant_type", "authorization_code")
-
-	resp, err := http.Get("https://api.weixin.qq.com/sns/oauth2/access_token?" + params.Encode())
+// completeLogin 用授权码换取第三方帐户信息。会话是绑定请求时绑定到发起绑定的用户，
+// 否则返回登录结果或首次登录时完成注册所需的受限令牌
+func (h *AuthHandler) completeLogin(ctx context.Context, provider oauth.IdentityProvider, code string, session loginSession) (map[string]interface{}, error) {
+	token, err := provider.Exchange(ctx, code, session.request)
 	if err != nil {
-		return "", "", err
+		log.Printf("%s 授权码换取令牌失败: %v", provider.Name(), err)
+		return nil, errors.New("Failed to get access token")
 	}
-	defer resp.Body.Close()
-
-	var result struct {
-		AccessToken string `json:"access_token"`
-		OpenID      string `json:"openid"`
-		ExpiresIn   int    `json:"expires_in"`
+	profile, err := provider.FetchProfile(ctx, token)
+	if err != nil {
+		log.Printf("获取 %s 用户信息失败: %v", provider.Name(), err)
+		return nil, errors.New("Failed to get user info")
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", "", err
+	account := models.PendingRegistration{
+		Provider:    provider.Name(),
+		ProviderID:  profile.ID,
+		Username:    profile.Name,
+		Email:       profile.Email,
+		AvatarURL:   profile.AvatarURL,
+		AccessToken: token.AccessToken,
 	}
-
-	return result.AccessToken, result.OpenID, nil
+	if session.linkUserID != 0 {
+		return h.linkIdentity(session.linkUserID, account)
+	}
+	return h.signInWithIdentity(account)
 }
 
-// getWechatUserInfo 获取微信用户信息
-func (h *AuthHandler) getWechatUserInfo(accessToken string, openID string) (*WechatUserInfo, error) {
-	params := url.Values{}
-	params.Add("access_token", accessToken)
-	params.Add("openid", openID)
-	params.Add("lang", "zh_CN")
-
-	resp, err := http.Get("https://api.weixin.qq.com/sns/userinfo?" + params.Encode())
-	if err != nil {
-		return nil, err
+// WechatLoginStatus 查询扫码登录状态：pending、scanned、confirmed 或 expired。
+// 确认后返回令牌和用户信息，首次登录时返回 registration_required 和完成注册的受限令牌，只返回一次
+func (h *AuthHandler) WechatLoginStatus(ctx iris.Context) {
+	state := ctx.URLParam("state")
+	if state == "" {
+		state = ctx.GetCookie(loginStateCookie)
 	}
-	defer resp.Body.Close()
-
-	var userInfo WechatUserInfo
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		return nil, err
+	if state == "" {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Login state is required"})
+		return
 	}
 
-	return &userInfo, nil
+	session := h.logins.check(state)
+	response := iris.Map{"status": session.status}
+	for key, value := range session.response {
+		response[key] = value
+	}
+	ctx.JSON(response)
 }
@@ -1,13 +1,40 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"hyper-pen-service/models"
+	"sort"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/kataras/iris/v12"
 	"gorm.io/gorm"
 )
 
+// categorySubtreeSQL 查询满足条件的分类及其所有子孙分类的ID，%s 为根分类的条件；UNION 去重可以防止异常数据中的环导致死循环
+const categorySubtreeSQL = `WITH RECURSIVE subtree(id) AS (
+	SELECT id FROM categories WHERE %s
+	UNION
+	SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+) SELECT id FROM subtree`
+
+// categoryNoteCountSQL 统计分类中未删除的笔记数量
+const categoryNoteCountSQL = "(SELECT COUNT(*) FROM notes WHERE notes.category_id = categories.id AND notes.deleted_at IS NULL) AS note_count"
+
+// 删除分类时对分类中笔记的处理方式
+const (
+	deleteStrategyMove         = "move"
+	deleteStrategyUncategorize = "uncategorize"
+	deleteStrategyTrash        = "trash"
+)
+
+var (
+	errParentNotFound         = errors.New("上级分类不存在")
+	errCategoryCycle          = errors.New("不能移动到自身或其子分类下")
+	errTargetCategoryNotFound = errors.New("目标分类不存在")
+)
+
 // CategoryHandler 处理分类相关的请求
 type CategoryHandler struct {
 	db *gorm.DB
@@ -18,30 +45,95 @@ func NewCategoryHandler(db *gorm.DB) *CategoryHandler {
 	return &CategoryHandler{db: db}
 }
 
-// GetCategories 获取所有分类
+// CategoryRequest 创建或更新分类的请求，ParentID 为 nil 时不修改上级分类，为空字符串时移动到顶级
+type CategoryRequest struct {
+	Name     string  `json:"name"`
+	ParentID *string `json:"parent_id"`
+}
+
+// MoveCategoryRequest 移动分类的请求
+type MoveCategoryRequest struct {
+	ParentID string `json:"parent_id"`
+}
+
+// GetCategories 获取所有分类，tree=true 时按层级返回顶级分类及其 children
 func (h *CategoryHandler) GetCategories(ctx iris.Context) {
 	userID := ctx.Values().Get("userID").(uint)
 	var categories []models.Category
-	if err := h.db.Where("user_id = ?", userID).Preload("Notes").Find(&categories).Error; err != nil {
+	if err := withNoteCount(h.db).Where("user_id = ?", userID).Order("name").Find(&categories).Error; err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(iris.Map{"error": "获取分类失败"})
 		return
 	}
+
+	if ctx.URLParamBoolDefault("tree", false) {
+		ctx.JSON(buildCategoryTree(categories))
+		return
+	}
 	ctx.JSON(categories)
 }
 
+// withNoteCount 查询分类时附带笔记数量，避免为了计数加载所有笔记
+func withNoteCount(db *gorm.DB) *gorm.DB {
+	return db.Select("categories.*, " + categoryNoteCountSQL)
+}
+
+// buildCategoryTree 把分类组织成树，上级分类不存在的分类视为顶级分类
+func buildCategoryTree(categories []models.Category) []*models.Category {
+	nodes := make(map[string]*models.Category, len(categories))
+	for i := range categories {
+		nodes[categories[i].ID] = &categories[i]
+	}
+
+	roots := []*models.Category{}
+	for i := range categories {
+		node := &categories[i]
+		if parent, ok := nodes[node.ParentID]; ok && node.ParentID != node.ID {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	byName := func(list []*models.Category) {
+		sort.SliceStable(list, func(i, j int) bool {
+			return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+		})
+	}
+	byName(roots)
+	for _, node := range nodes {
+		byName(node.Children)
+	}
+	return roots
+}
+
 // CreateCategory 创建分类
 func (h *CategoryHandler) CreateCategory(ctx iris.Context) {
 	userID := ctx.Values().Get("userID").(uint)
-	var category models.Category
-	if err := ctx.ReadJSON(&category); err != nil {
+	var req CategoryRequest
+	if err := ctx.ReadJSON(&req); err != nil {
 		ctx.StatusCode(iris.StatusBadRequest)
 		ctx.JSON(iris.Map{"error": "无效的请求数据"})
 		return
 	}
+	if strings.TrimSpace(req.Name) == "" {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "分类名称不能为空"})
+		return
+	}
 
-	category.ID = uuid.New().String()
-	category.UserID = userID
+	category := models.Category{
+		ID:     uuid.New().String(),
+		Name:   req.Name,
+		UserID: userID,
+	}
+	if req.ParentID != nil && *req.ParentID != "" {
+		if err := checkCategoryParent(h.db, userID, category.ID, *req.ParentID); err != nil {
+			writeCategoryError(ctx, err, "创建分类失败")
+			return
+		}
+		category.ParentID = *req.ParentID
+	}
 
 	if err := h.db.Create(&category).Error; err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
@@ -57,33 +149,220 @@ func (h *CategoryHandler) CreateCategory(ctx iris.Context) {
 func (h *CategoryHandler) UpdateCategory(ctx iris.Context) {
 	userID := ctx.Values().Get("userID").(uint)
 	id := ctx.Params().Get("id")
-	var category models.Category
-	if err := ctx.ReadJSON(&category); err != nil {
+	var req CategoryRequest
+	if err := ctx.ReadJSON(&req); err != nil {
 		ctx.StatusCode(iris.StatusBadRequest)
 		ctx.JSON(iris.Map{"error": "无效的请求数据"})
 		return
 	}
 
 	var existingCategory models.Category
-	if err := h.db.Where("id = ? AND user_id = ?", id, userID).First(&existingCategory).Error; err != nil {
+	if err := withNoteCount(h.db).Where("id = ? AND user_id = ?", id, userID).First(&existingCategory).Error; err != nil {
 		ctx.StatusCode(iris.StatusNotFound)
 		ctx.JSON(iris.Map{"error": "分类不存在"})
 		return
 	}
 
-	if err := h.db.Model(&existingCategory).Updates(category).Error; err != nil {
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": "更新分类失败"})
-		return
+	updates := map[string]interface{}{}
+	if strings.TrimSpace(req.Name) != "" {
+		updates["name"] = req.Name
+	}
+	if req.ParentID != nil && *req.ParentID != existingCategory.ParentID {
+		if err := checkCategoryParent(h.db, userID, id, *req.ParentID); err != nil {
+			writeCategoryError(ctx, err, "更新分类失败")
+			return
+		}
+		updates["parent_id"] = *req.ParentID
+	}
+
+	if len(updates) > 0 {
+		if err := h.db.Model(&existingCategory).Updates(updates).Error; err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "更新分类失败"})
+			return
+		}
 	}
 
 	ctx.JSON(existingCategory)
 }
 
-// DeleteCategory 删除分类
+// MoveCategory 把分类连同其所有子分类移动到 parent_id 下，parent_id 为空时移动到顶级
+func (h *CategoryHandler) MoveCategory(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+	id := ctx.Params().Get("id")
+	var req MoveCategoryRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "无效的请求数据"})
+		return
+	}
+
+	var category models.Category
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := withNoteCount(tx).Where("id = ? AND user_id = ?", id, userID).First(&category).Error; err != nil {
+			return err
+		}
+		if err := checkCategoryParent(tx, userID, id, req.ParentID); err != nil {
+			return err
+		}
+		return tx.Model(&category).Update("parent_id", req.ParentID).Error
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.JSON(iris.Map{"error": "分类不存在"})
+			return
+		}
+		writeCategoryError(ctx, err, "移动分类失败")
+		return
+	}
+
+	ctx.JSON(category)
+}
+
+// checkCategoryParent 检查 parentID 是否可以作为分类 id 的上级：必须属于同一用户，且不能是分类自身或其子孙
+func checkCategoryParent(tx *gorm.DB, userID uint, id, parentID string) error {
+	if parentID == "" {
+		return nil
+	}
+
+	var count int64
+	if err := tx.Model(&models.Category{}).Where("id = ? AND user_id = ?", parentID, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return errParentNotFound
+	}
+
+	subtree, err := categorySubtree(tx, userID, id)
+	if err != nil {
+		return err
+	}
+	for _, descendant := range subtree {
+		if descendant == parentID {
+			return errCategoryCycle
+		}
+	}
+	return nil
+}
+
+// categorySubtree 返回分类及其所有子孙分类的ID
+func categorySubtree(tx *gorm.DB, userID uint, id string) ([]string, error) {
+	var ids []string
+	err := tx.Raw(fmt.Sprintf(categorySubtreeSQL, "id = ? AND user_id = ?"), id, userID).Scan(&ids).Error
+	return ids, err
+}
+
+// categoryPaths 返回用户每个分类从顶级分类到自身的名称；上级分类不存在或数据中出现环时，路径从该处开始
+func categoryPaths(tx *gorm.DB, userID uint) (map[string][]string, error) {
+	var categories []models.Category
+	if err := tx.Select("id", "name", "parent_id").Where("user_id = ?", userID).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.Category, len(categories))
+	for i := range categories {
+		byID[categories[i].ID] = &categories[i]
+	}
+
+	paths := make(map[string][]string, len(categories))
+	for _, category := range categories {
+		var names []string
+		visited := make(map[string]bool)
+		for node := byID[category.ID]; node != nil && !visited[node.ID]; node = byID[node.ParentID] {
+			visited[node.ID] = true
+			names = append([]string{node.Name}, names...)
+		}
+		paths[category.ID] = names
+	}
+	return paths, nil
+}
+
+// joinCategoryPath 把各级分类名称拼接为以 / 分隔的路径，名称中的 / 和 \ 用 \ 转义
+func joinCategoryPath(names []string) string {
+	escaped := make([]string, len(names))
+	for i, name := range names {
+		escaped[i] = strings.NewReplacer(`\`, `\\`, "/", `\/`).Replace(name)
+	}
+	return strings.Join(escaped, "/")
+}
+
+// splitCategoryPath 把 joinCategoryPath 生成的路径拆分为各级分类名称，忽略空的层级
+func splitCategoryPath(p string) []string {
+	var names []string
+	var b strings.Builder
+	flush := func() {
+		if name := strings.TrimSpace(b.String()); name != "" {
+			names = append(names, name)
+		}
+		b.Reset()
+	}
+
+	runes := []rune(p)
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == '/' || runes[i+1] == '\\'):
+			i++
+			b.WriteRune(runes[i])
+		case runes[i] == '/':
+			flush()
+		default:
+			b.WriteRune(runes[i])
+		}
+	}
+	flush()
+	return names
+}
+
+// filterCategory 按分类过滤笔记，includeDescendants 为 true 时包含所有子孙分类中的笔记
+func filterCategory(db *gorm.DB, userID uint, categoryID string, includeDescendants bool) *gorm.DB {
+	if !includeDescendants {
+		return db.Where("notes.category_id = ?", categoryID)
+	}
+	return db.Where("notes.category_id IN ("+fmt.Sprintf(categorySubtreeSQL, "id = ? AND user_id = ?")+")", categoryID, userID)
+}
+
+// writeCategoryError 把分类校验错误转换为响应
+func writeCategoryError(ctx iris.Context, err error, message string) {
+	switch err {
+	case errParentNotFound:
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": err.Error()})
+	case errCategoryCycle:
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(iris.Map{"error": err.Error()})
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": message})
+	}
+}
+
+// DeleteCategory 删除分类，子分类移动到被删除分类的上级下。strategy 指定分类中笔记的处理方式：
+// move 移动到 target_id 指定的分类，uncategorize（默认）变为未分类，trash 移入回收站并记下分类路径，恢复时重新创建分类
 func (h *CategoryHandler) DeleteCategory(ctx iris.Context) {
 	userID := ctx.Values().Get("userID").(uint)
 	id := ctx.Params().Get("id")
+	strategy := ctx.URLParamDefault("strategy", deleteStrategyUncategorize)
+	targetID := ctx.URLParam("target_id")
+
+	switch strategy {
+	case deleteStrategyMove:
+		if targetID == "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(iris.Map{"error": "移动笔记时必须指定目标分类"})
+			return
+		}
+		if targetID == id {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(iris.Map{"error": "目标分类不能是被删除的分类"})
+			return
+		}
+	case deleteStrategyUncategorize, deleteStrategyTrash:
+		targetID = ""
+	default:
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "无效的删除方式，可选 move、uncategorize、trash"})
+		return
+	}
 
 	var category models.Category
 	if err := h.db.Where("id = ? AND user_id = ?", id, userID).First(&category).Error; err != nil {
@@ -92,11 +371,72 @@ func (h *CategoryHandler) DeleteCategory(ctx iris.Context) {
 		return
 	}
 
-	if err := h.db.Delete(&category).Error; err != nil {
+	var affected int64
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if targetID != "" {
+			var count int64
+			if err := tx.Model(&models.Category{}).Where("id = ? AND user_id = ?", targetID, userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return errTargetCategoryNotFound
+			}
+		}
+
+		var noteIDs []string
+		if err := tx.Model(&models.Note{}).Where("category_id = ? AND user_id = ?", category.ID, userID).Pluck("id", &noteIDs).Error; err != nil {
+			return err
+		}
+		affected = int64(len(noteIDs))
+
+		// 回收站中的笔记也一并处理，恢复后不会指向已删除的分类
+		updates := map[string]interface{}{"category_id": targetID}
+		if strategy == deleteStrategyTrash {
+			paths, err := categoryPaths(tx, userID)
+			if err != nil {
+				return err
+			}
+			updates["deleted_category_path"] = joinCategoryPath(paths[category.ID])
+		}
+		if err := tx.Unscoped().Model(&models.Note{}).Where("category_id = ? AND user_id = ?", category.ID, userID).
+			Updates(updates).Error; err != nil {
+			return err
+		}
+		if strategy == deleteStrategyTrash && len(noteIDs) > 0 {
+			if err := tx.Where("id IN ?", noteIDs).Delete(&models.Note{}).Error; err != nil {
+				return err
+			}
+			if err := refreshBrokenLinks(tx, userID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.Category{}).Where("parent_id = ? AND user_id = ?", category.ID, userID).
+			Update("parent_id", category.ParentID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("category_id = ?", category.ID).Delete(&models.Permission{}).Error; err != nil {
+			return err
+		}
+		if _, err := deleteShareLinks(tx, "category_id", category.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&category).Error
+	})
+	if err != nil {
+		if err == errTargetCategoryNotFound {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(iris.Map{"error": err.Error()})
+			return
+		}
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(iris.Map{"error": "删除分类失败"})
 		return
 	}
 
-	ctx.JSON(iris.Map{"message": "分类已删除"})
+	ctx.JSON(iris.Map{
+		"message":        "分类已删除",
+		"strategy":       strategy,
+		"affected_notes": affected,
+	})
 }
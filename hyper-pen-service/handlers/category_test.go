@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCategoryPath(t *testing.T) {
+	tests := []struct {
+		names []string
+		path  string
+	}{
+		{names: []string{"Work"}, path: "Work"},
+		{names: []string{"Work", "Projects", "项目 X"}, path: "Work/Projects/项目 X"},
+		{names: []string{"A/B", "C"}, path: `A\/B/C`},
+		{names: []string{`back\slash`}, path: `back\\slash`},
+		{names: []string{`trailing\`, "x"}, path: `trailing\\/x`},
+	}
+
+	for _, tt := range tests {
+		if got := joinCategoryPath(tt.names); got != tt.path {
+			t.Errorf("joinCategoryPath(%q) = %q, want %q", tt.names, got, tt.path)
+		}
+		if got := splitCategoryPath(tt.path); !reflect.DeepEqual(got, tt.names) {
+			t.Errorf("splitCategoryPath(%q) = %q, want %q", tt.path, got, tt.names)
+		}
+	}
+}
+
+func TestSplitCategoryPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "", want: nil},
+		{path: "/", want: nil},
+		{path: "/Work//Notes/", want: []string{"Work", "Notes"}},
+		{path: " Work / Notes ", want: []string{"Work", "Notes"}},
+		{path: `C:\Users`, want: []string{`C:\Users`}},
+	}
+
+	for _, tt := range tests {
+		if got := splitCategoryPath(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCategoryPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
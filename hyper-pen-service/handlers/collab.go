@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"errors"
+	"hyper-pen-service/collab"
+	"hyper-pen-service/models"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+const (
+	// collabWriteWait 单条消息的写超时
+	collabWriteWait = 10 * time.Second
+	// collabPongWait 等待客户端 pong 的最长时间
+	collabPongWait = 60 * time.Second
+	// collabPingPeriod 发送 ping 的间隔，需小于 collabPongWait
+	collabPingPeriod = collabPongWait * 9 / 10
+	// collabMaxMessageSize 客户端单条消息的大小上限
+	collabMaxMessageSize = 1 << 20
+)
+
+// 身份校验使用 Authorization 请求头或一次性票据而不是 Cookie，不存在跨站 WebSocket 劫持的问题，因此不限制 Origin
+var collabUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// CollabHandler 处理笔记实时协同编辑的 WebSocket 连接
+type CollabHandler struct {
+	db  *gorm.DB
+	hub *collab.Hub
+	// tickets 浏览器建立连接时使用的一次性票据，避免长期有效的 JWT 出现在地址中
+	tickets *exchangeCodeStore
+}
+
+// NewCollabHandler 创建新的协同编辑处理器，文档通过 NoteHandler 的更新流程持久化
+func NewCollabHandler(db *gorm.DB, notes *NoteHandler) *CollabHandler {
+	return &CollabHandler{
+		db:      db,
+		hub:     collab.NewHub(&collabStore{notes: notes}),
+		tickets: newExchangeCodeStore(),
+	}
+}
+
+// collabMessage 客户端发来的消息
+type collabMessage struct {
+	Type      string            `json:"type"`
+	Revision  int               `json:"revision"`
+	Operation *collab.Operation `json:"operation"`
+}
+
+// RecheckUser 在用户的共享权限被修改或取消后断开其已无权编辑的协同编辑连接
+func (h *CollabHandler) RecheckUser(userID uint) {
+	h.hub.Recheck(userID)
+}
+
+// IssueTicket 签发建立协同编辑连接的一次性票据，浏览器的 WebSocket 无法设置请求头，握手时通过 ticket 查询参数传递
+func (h *CollabHandler) IssueTicket(ctx iris.Context) {
+	id := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	if _, err := accessibleNote(h.db, userID, id, models.RoleEditor); err != nil {
+		writeNoteAccessError(ctx, err)
+		return
+	}
+
+	ticket, err := h.tickets.issue(map[string]interface{}{"user_id": userID, "note_id": id})
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to issue ticket"})
+		return
+	}
+	ctx.JSON(iris.Map{
+		"ticket":     ticket,
+		"expires_in": int(exchangeCodeTTL.Seconds()),
+	})
+}
+
+// Connect 建立笔记的协同编辑连接，使用 Authorization 请求头或 IssueTicket 签发的票据验证身份
+func (h *CollabHandler) Connect(ctx iris.Context) {
+	id := ctx.Params().Get("id")
+	userID, ok := ctx.Values().Get("userID").(uint)
+	if !ok {
+		ticket, valid := h.tickets.redeem(ctx.URLParam("ticket"))
+		if !valid || ticket["note_id"] != id {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.JSON(iris.Map{"error": "Invalid or expired ticket"})
+			return
+		}
+		userID = ticket["user_id"].(uint)
+	}
+
+	if _, err := accessibleNote(h.db, userID, id, models.RoleEditor); err != nil {
+		writeNoteAccessError(ctx, err)
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("id", "username").First(&user, userID).Error; err != nil {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(iris.Map{"error": "User not found"})
+		return
+	}
+
+	conn, err := collabUpgrader.Upgrade(ctx.ResponseWriter(), ctx.Request(), nil)
+	if err != nil {
+		// Upgrade 失败时已经写入了错误响应
+		return
+	}
+	defer conn.Close()
+
+	client, err := h.hub.Join(id, user.ID, user.Username)
+	if err != nil {
+		conn.WriteJSON(collab.Message{Type: collab.MessageError, Error: "Failed to load note"})
+		return
+	}
+	defer h.hub.Leave(id, client)
+
+	go writeCollabMessages(conn, client)
+
+	conn.SetReadLimit(collabMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(collabPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(collabPongWait))
+	})
+
+	for {
+		var msg collabMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Type == collab.MessageOp {
+			h.hub.Receive(id, client, msg.Revision, msg.Operation)
+		}
+	}
+}
+
+// writeCollabMessages 把发送队列中的消息写入连接，并定期发送 ping
+func writeCollabMessages(conn *websocket.Conn, client *collab.Client) {
+	ticker := time.NewTicker(collabPingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-client.Send:
+			conn.SetWriteDeadline(time.Now().Add(collabWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(collabWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// collabStore 通过笔记的常规更新流程加载和保存协同编辑文档，保证修订版本和标签一致
+type collabStore struct {
+	notes *NoteHandler
+}
+
+func (s *collabStore) Load(noteID string) (*collab.Document, error) {
+	var note models.Note
+	if err := s.notes.db.Where("id = ?", noteID).First(&note).Error; err != nil {
+		return nil, err
+	}
+	return &collab.Document{
+		Title:   note.Title,
+		Content: note.Content,
+		Version: note.Version,
+	}, nil
+}
+
+func (s *collabStore) Save(noteID string, doc *collab.Document) (*collab.Document, error) {
+	var current models.Note
+	if err := s.notes.db.Where("id = ?", noteID).First(&current).Error; err != nil {
+		return nil, err
+	}
+
+	note, _, err := s.notes.updateNote(doc.EditorID, noteID, NoteRequest{
+		Title:      current.Title,
+		Content:    doc.Content,
+		CategoryID: current.CategoryID,
+		Version:    doc.Version,
+	})
+	if err != nil {
+		var conflict *versionConflictError
+		if errors.As(err, &conflict) {
+			return nil, collab.ErrConflict
+		}
+		if errors.Is(err, errNoteForbidden) || errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, collab.ErrForbidden
+		}
+		return nil, err
+	}
+
+	return &collab.Document{
+		Title:   note.Title,
+		Content: note.Content,
+		Version: note.Version,
+	}, nil
+}
+
+func (s *collabStore) CanEdit(noteID string, userID uint) (bool, error) {
+	_, err := accessibleNote(s.notes.db, userID, noteID, models.RoleEditor)
+	if errors.Is(err, errNoteForbidden) || errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
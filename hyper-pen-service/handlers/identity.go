@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hyper-pen-service/models"
+	"hyper-pen-service/utils"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+var (
+	errIdentityInUse  = errors.New("This account is already linked to another user")
+	errProviderLinked = errors.New("An account of this provider is already linked, unlink it first")
+)
+
+// findIdentityUser 查找绑定了第三方帐户的用户
+func findIdentityUser(db *gorm.DB, provider, providerID string) (*models.User, error) {
+	var user models.User
+	err := db.Joins("JOIN identities ON identities.user_id = users.id").
+		Where("identities.provider = ? AND identities.provider_id = ?", provider, providerID).
+		First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// signInWithIdentity 使用第三方帐户登录：已绑定的用户返回令牌和用户信息，首次登录时进入注册流程
+func (h *AuthHandler) signInWithIdentity(account models.PendingRegistration) (map[string]interface{}, error) {
+	user, err := findIdentityUser(h.db, account.Provider, account.ProviderID)
+	if err == gorm.ErrRecordNotFound {
+		response, err := h.startRegistration(account)
+		if err != nil {
+			return nil, errors.New("Failed to start registration")
+		}
+		return response, nil
+	} else if err != nil {
+		return nil, errors.New("Failed to find user")
+	}
+
+	if account.Provider == models.ProviderGitHub {
+		// 更新现有用户信息
+		user.AvatarURL = account.AvatarURL
+		user.GitHubToken = account.AccessToken
+		if err := h.db.Save(user).Error; err != nil {
+			return nil, errors.New("Failed to update user")
+		}
+	}
+
+	// 生成JWT令牌
+	token, err := utils.GenerateToken(user)
+	if err != nil {
+		return nil, errors.New("Failed to generate token")
+	}
+	return map[string]interface{}{
+		"token": token,
+		"user": map[string]interface{}{
+			"id":         user.ID,
+			"username":   user.Username,
+			"email":      user.Email,
+			"avatar_url": user.AvatarURL,
+		},
+	}, nil
+}
+
+// linkIdentity 把第三方帐户绑定到已登录的用户，重复绑定同一个帐户时直接返回成功
+func (h *AuthHandler) linkIdentity(userID uint, account models.PendingRegistration) (map[string]interface{}, error) {
+	identity := models.Identity{
+		UserID:     userID,
+		Provider:   account.Provider,
+		ProviderID: account.ProviderID,
+		Name:       account.Username,
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var existing models.Identity
+		err := tx.Where("provider = ? AND provider_id = ?", account.Provider, account.ProviderID).First(&existing).Error
+		if err == nil {
+			if existing.UserID != userID {
+				return errIdentityInUse
+			}
+			identity = existing
+			return nil
+		}
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.Identity{}).Where("user_id = ? AND provider = ?", userID, account.Provider).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errProviderLinked
+		}
+		if err := tx.Create(&identity).Error; err != nil {
+			return err
+		}
+		if account.Provider == models.ProviderGitHub {
+			return tx.Model(&models.User{}).Where("id = ?", userID).Update("git_hub_token", account.AccessToken).Error
+		}
+		return nil
+	})
+	if err == errIdentityInUse || err == errProviderLinked {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New("Failed to link account")
+	}
+
+	return map[string]interface{}{
+		"linked":   true,
+		"identity": identity,
+	}, nil
+}
+
+// LinkIdentity 开始为当前用户绑定第三方帐户，返回授权地址。授权完成后回调跳转回前端，
+// 绑定结果通过一次性授权码取走；微信扫码后通过 /api/auth/wechat/check 轮询结果
+func (h *AuthHandler) LinkIdentity(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	provider, ok := h.providers[ctx.Params().Get("provider")]
+	if !ok {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Unsupported provider"})
+		return
+	}
+	// 微信扫码绑定的结果通过轮询取走，其他提供方回调后跳转回前端
+	authURL, state, err := h.beginLogin(ctx, provider, userID, provider.Name() == models.ProviderWechat)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to start login"})
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"auth_url":   authURL,
+		"state":      state,
+		"expires_in": int(loginSessionTTL.Seconds()),
+	})
+}
+
+// MigrateLegacyIdentities 把早期版本保存在 users 表 github_id、wechat_id 列中的第三方帐户迁移到 identities 表，
+// 迁移后清空旧列，可以重复执行
+func MigrateLegacyIdentities(db *gorm.DB) error {
+	columns := []struct{ provider, column string }{
+		{models.ProviderGitHub, "github_id"},
+		{models.ProviderWechat, "wechat_id"},
+	}
+	now := time.Now()
+	for _, c := range columns {
+		if !db.Migrator().HasColumn(&models.User{}, c.column) {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(fmt.Sprintf(`INSERT INTO identities (user_id, provider, provider_id, name, created_at, updated_at)
+				SELECT id, ?, %[1]s, username, ?, ? FROM users
+				WHERE %[1]s IS NOT NULL AND %[1]s <> ''
+				AND NOT EXISTS (SELECT 1 FROM identities WHERE identities.provider = ? AND identities.provider_id = users.%[1]s)`, c.column),
+				c.provider, now, now, c.provider).Error; err != nil {
+				return err
+			}
+			return tx.Exec(fmt.Sprintf("UPDATE users SET %s = NULL", c.column)).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
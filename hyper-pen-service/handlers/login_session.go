@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"hyper-pen-service/oauth"
+	"sync"
+	"time"
+)
+
+// 第三方登录会话的状态。微信扫码登录时浏览器轮询这些状态
+const (
+	loginPending   = "pending"
+	loginScanned   = "scanned"
+	loginConfirmed = "confirmed"
+	loginExpired   = "expired"
+)
+
+// loginSessionTTL 第三方登录会话（包括微信二维码）的有效期
+const loginSessionTTL = 5 * time.Minute
+
+// loginStateCookie 保存当前浏览器发起的第三方登录 state 的 Cookie
+const loginStateCookie = "oauth_login_state"
+
+// loginSession 一次第三方登录或绑定会话，回调后保存返回给浏览器的结果
+type loginSession struct {
+	provider string
+	// request 发起授权时的 state、nonce 和 PKCE 参数，换取令牌时使用
+	request oauth.AuthRequest
+	// linkUserID 非 0 表示把第三方帐户绑定到该用户，而不是登录
+	linkUserID uint
+	// poll 为 true 时结果由浏览器轮询取走（微信扫码），否则回调后跳转回前端
+	poll      bool
+	status    string
+	response  map[string]interface{}
+	expiresAt time.Time
+}
+
+// loginSessionStore 以 OAuth state 为键保存进行中的登录会话，只保存在内存中
+type loginSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*loginSession
+}
+
+func newLoginSessionStore() *loginSessionStore {
+	return &loginSessionStore{sessions: make(map[string]*loginSession)}
+}
+
+// create 创建等待回调的会话，同时清理已过期的会话
+func (s *loginSessionStore) create(req oauth.AuthRequest, provider string, linkUserID uint, poll bool) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, session := range s.sessions {
+		if now.After(session.expiresAt) {
+			delete(s.sessions, key)
+		}
+	}
+	expiresAt := now.Add(loginSessionTTL)
+	s.sessions[req.State] = &loginSession{
+		provider:   provider,
+		request:    req,
+		linkUserID: linkUserID,
+		poll:       poll,
+		status:     loginPending,
+		expiresAt:  expiresAt,
+	}
+	return expiresAt
+}
+
+// scan 回调到达时把等待中的会话标记为已扫码并返回会话。state 不存在、提供方不一致、
+// 已过期或已使用时返回 false
+func (s *loginSessionStore) scan(state, provider string) (loginSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[state]
+	if !ok || session.provider != provider || time.Now().After(session.expiresAt) || session.status != loginPending {
+		return loginSession{}, false
+	}
+	session.status = loginScanned
+	return *session, true
+}
+
+// confirm 保存登录结果，等待轮询的浏览器取走
+func (s *loginSessionStore) confirm(state string, response map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[state]; ok {
+		session.status = loginConfirmed
+		session.response = response
+	}
+}
+
+// remove 删除会话，用于回调失败或不需要轮询的情况
+func (s *loginSessionStore) remove(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, state)
+}
+
+// check 返回会话当前状态。已确认的会话返回登录结果后即删除，令牌只能取走一次；
+// 不存在的会话视为已过期
+func (s *loginSessionStore) check(state string) loginSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[state]
+	if !ok {
+		return loginSession{status: loginExpired}
+	}
+	if session.status == loginConfirmed {
+		delete(s.sessions, state)
+		return *session
+	}
+	if time.Now().After(session.expiresAt) {
+		delete(s.sessions, state)
+		return loginSession{status: loginExpired}
+	}
+	return *session
+}
+
+// exchangeCodeTTL 一次性授权码的有效期，用于第三方登录回调后兑换令牌和建立协同编辑连接
+const exchangeCodeTTL = time.Minute
+
+// exchangeCode 回调结果，等待前端用一次性授权码取走
+type exchangeCode struct {
+	response  map[string]interface{}
+	expiresAt time.Time
+}
+
+// exchangeCodeStore 保存回调结果的一次性授权码，令牌不出现在跳转地址中，只保存在内存中
+type exchangeCodeStore struct {
+	mu    sync.Mutex
+	codes map[string]*exchangeCode
+}
+
+func newExchangeCodeStore() *exchangeCodeStore {
+	return &exchangeCodeStore{codes: make(map[string]*exchangeCode)}
+}
+
+// issue 保存回调结果并返回授权码，同时清理已过期的授权码
+func (s *exchangeCodeStore) issue(response map[string]interface{}) (string, error) {
+	code, err := oauth.RandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, c := range s.codes {
+		if now.After(c.expiresAt) {
+			delete(s.codes, key)
+		}
+	}
+	s.codes[code] = &exchangeCode{response: response, expiresAt: now.Add(exchangeCodeTTL)}
+	return code, nil
+}
+
+// redeem 取走授权码对应的回调结果，授权码只能使用一次，不存在或已过期时返回 false
+func (s *exchangeCodeStore) redeem(code string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.codes[code]
+	if !ok {
+		return nil, false
+	}
+	delete(s.codes, code)
+	if time.Now().After(c.expiresAt) {
+		return nil, false
+	}
+	return c.response, true
+}
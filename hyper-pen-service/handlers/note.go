@@ -1,7 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"hyper-pen-service/models"
+	"hyper-pen-service/render"
+	"hyper-pen-service/utils"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/kataras/iris/v12"
@@ -10,12 +16,13 @@ import (
 
 // NoteHandler 处理笔记相关的请求
 type NoteHandler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	renderer *render.Renderer
 }
 
 // NewNoteHandler 创建新的笔记处理器
-func NewNoteHandler(db *gorm.DB) *NoteHandler {
-	return &NoteHandler{db: db}
+func NewNoteHandler(db *gorm.DB, renderer *render.Renderer) *NoteHandler {
+	return &NoteHandler{db: db, renderer: renderer}
 }
 
 type NoteRequest struct {
@@ -23,6 +30,7 @@ type NoteRequest struct {
 	Content    string   `json:"content"`
 	CategoryID string   `json:"category_id"`
 	TagIDs     []string `json:"tag_ids"`
+	Version    int      `json:"version"`
 }
 
 // CreateNote 创建笔记
@@ -44,6 +52,7 @@ func (h *NoteHandler) CreateNote(ctx iris.Context) {
 		Title:      req.Title,
 		Content:    req.Content,
 		CategoryID: req.CategoryID,
+		Version:    1,
 	}
 
 	// 开始事务
@@ -74,6 +83,22 @@ func (h *NoteHandler) CreateNote(ctx iris.Context) {
 		}
 	}
 
+	// 更新双链
+	if err := syncNoteLinks(tx, &note); err != nil {
+		tx.Rollback()
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to update note links"})
+		return
+	}
+
+	// 记录修订版本
+	if err := recordRevision(tx, &note, note.UserID); err != nil {
+		tx.Rollback()
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to save revision"})
+		return
+	}
+
 	// 提交事务
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
@@ -95,21 +120,26 @@ func (h *NoteHandler) CreateNote(ctx iris.Context) {
 	})
 }
 
-// GetNotes 获取笔记列表
+// GetNotes 获取笔记列表，支持游标分页、排序和时间过滤，可按 category_id 过滤，include_descendants=true 时包含子孙分类
 func (h *NoteHandler) GetNotes(ctx iris.Context) {
 	userID := ctx.Values().Get("userID").(uint)
 
-	var notes []models.Note
-	if err := h.db.Where("user_id = ?", userID).Order("created_at desc").Preload("Tags").Preload("Category").Find(&notes).Error; err != nil {
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": "Failed to fetch notes"})
+	opts, err := parseNoteListOptions(ctx, false)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": err.Error()})
 		return
 	}
 
-	ctx.JSON(notes)
+	db := h.db.Where("notes.user_id = ?", userID)
+	if categoryID := ctx.URLParam("category_id"); categoryID != "" {
+		db = filterCategory(db, userID, categoryID, ctx.URLParamBoolDefault("include_descendants", false))
+	}
+
+	h.listNotes(ctx, db, opts, nil)
 }
 
-// GetNote 获取单个笔记
+// GetNote 获取单个笔记，所有者和协作者都可以访问，role 为当前用户的角色
 func (h *NoteHandler) GetNote(ctx iris.Context) {
 	id := ctx.Params().Get("id")
 	if id == "" {
@@ -120,22 +150,51 @@ func (h *NoteHandler) GetNote(ctx iris.Context) {
 
 	userID := ctx.Values().Get("userID").(uint)
 
-	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ?", id, userID).Preload("Tags").Preload("Category").First(&note).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			ctx.StatusCode(iris.StatusNotFound)
-			ctx.JSON(iris.Map{"error": "Note not found"})
+	format, err := noteFormat(ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Invalid format, expected markdown or html"})
+		return
+	}
+
+	note, err := accessibleNote(h.db.Preload("Tags").Preload("Category"), userID, id, models.RoleViewer)
+	if err != nil {
+		writeNoteAccessError(ctx, err)
+		return
+	}
+
+	// 正文会被编辑后保存，只在渲染结果中写入凭证
+	note.AttachmentQuery = userAttachmentQuery(note.ID, userID)
+	if format == formatHTML {
+		html, err := h.renderer.Render(note.ID, note.Version, note.Content)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "Failed to render note"})
 			return
 		}
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": "Failed to fetch note"})
-		return
+		note.HTML = attachmentURLsWithQuery(html, note.ID, note.AttachmentQuery)
 	}
 
+	ctx.Header("ETag", noteETag(note))
 	ctx.JSON(note)
 }
 
-// UpdateNote 更新笔记
+// GetSharedNotes 获取其他用户直接或通过分类共享给当前用户的笔记，分页、排序和时间过滤参数与 GetNotes 相同
+func (h *NoteHandler) GetSharedNotes(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	opts, err := parseNoteListOptions(ctx, false)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": err.Error()})
+		return
+	}
+
+	db := h.db.Where("notes.user_id <> ?", userID).Where(sharedNoteCondition, userID, userID)
+	h.listNotes(ctx, db, opts, nil)
+}
+
+// UpdateNote 更新笔记，需要通过 If-Match 请求头或 version 字段提供客户端持有的版本号
 func (h *NoteHandler) UpdateNote(ctx iris.Context) {
 	id := ctx.Params().Get("id")
 	if id == "" {
@@ -151,74 +210,224 @@ func (h *NoteHandler) UpdateNote(ctx iris.Context) {
 		return
 	}
 
-	userID := ctx.Values().Get("userID").(uint)
-
-	// 开始事务
-	tx := h.db.Begin()
-
-	var note models.Note
-	if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&note).Error; err != nil {
-		tx.Rollback()
-		if err == gorm.ErrRecordNotFound {
-			ctx.StatusCode(iris.StatusNotFound)
-			ctx.JSON(iris.Map{"error": "Note not found"})
+	if ifMatch := ctx.GetHeader("If-Match"); ifMatch != "" {
+		version, ok := parseETag(ifMatch)
+		if !ok {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(iris.Map{"error": "Invalid If-Match header"})
 			return
 		}
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": "Failed to fetch note"})
+		req.Version = version
+	}
+	if req.Version <= 0 {
+		ctx.StatusCode(iris.StatusPreconditionRequired)
+		ctx.JSON(iris.Map{"error": "Note version is required"})
 		return
 	}
 
-	note.Title = req.Title
-	note.Content = req.Content
-	note.CategoryID = req.CategoryID
+	userID := ctx.Values().Get("userID").(uint)
 
-	if err := tx.Save(&note).Error; err != nil {
-		tx.Rollback()
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": "Failed to update note"})
+	note, relinked, err := h.updateNote(userID, id, req)
+	if err != nil {
+		var conflict *versionConflictError
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, errNoteForbidden):
+			writeNoteAccessError(ctx, err)
+		case errors.As(err, &conflict):
+			h.writeConflict(ctx, conflict.current, req)
+		default:
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "Failed to update note"})
+		}
 		return
 	}
 
-	// 更新标签关联
-	if len(req.TagIDs) > 0 {
-		var tags []models.Tag
-		if err := tx.Where("id IN ?", req.TagIDs).Find(&tags).Error; err != nil {
-			tx.Rollback()
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.JSON(iris.Map{"error": "Failed to update tags"})
-			return
+	if relinked == nil {
+		relinked = []RelinkedNote{}
+	}
+
+	ctx.Header("ETag", noteETag(note))
+	ctx.JSON(iris.Map{
+		"message":        "Note updated successfully",
+		"note":           note,
+		"relinked_notes": relinked,
+	})
+}
+
+// versionConflictError 客户端提交的版本落后于服务器版本
+type versionConflictError struct {
+	current *models.Note
+}
+
+func (e *versionConflictError) Error() string {
+	return fmt.Sprintf("version conflict: server version is %d", e.current.Version)
+}
+
+// updateNote 在一个事务中校验权限和版本、更新笔记、更新标签、改写指向旧标题的链接并记录修订版本，
+// 返回重新加载后的笔记和被改写链接的笔记。userID 需要是所有者或编辑者，编辑者不能修改笔记的分类
+func (h *NoteHandler) updateNote(userID uint, id string, req NoteRequest) (*models.Note, []RelinkedNote, error) {
+	var note models.Note
+	var relinked []RelinkedNote
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		found, err := accessibleNote(tx, userID, id, models.RoleEditor)
+		if err != nil {
+			return err
 		}
-		if err := tx.Model(&note).Association("Tags").Replace(tags); err != nil {
-			tx.Rollback()
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.JSON(iris.Map{"error": "Failed to update tags"})
-			return
+		note = *found
+		if note.Role != models.RoleOwner {
+			req.CategoryID = note.CategoryID
 		}
-	}
+		if note.Version != req.Version {
+			return &versionConflictError{current: &note}
+		}
+		oldTitle := note.Title
 
-	// 提交事务
-	if err := tx.Commit().Error; err != nil {
-		tx.Rollback()
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": "Failed to update note"})
-		return
+		// 旧笔记补记修改前的内容
+		if err := ensureRevisionBaseline(tx, &note); err != nil {
+			return err
+		}
+
+		// 只有版本号未变时才写入，防止并发请求互相覆盖
+		result := tx.Model(&note).Where("version = ?", req.Version).Updates(map[string]interface{}{
+			"title":       req.Title,
+			"content":     req.Content,
+			"category_id": req.CategoryID,
+			"version":     req.Version + 1,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if err := tx.Where("id = ?", id).First(&note).Error; err != nil {
+				return err
+			}
+			return &versionConflictError{current: &note}
+		}
+		note.Title = req.Title
+		note.Content = req.Content
+		note.CategoryID = req.CategoryID
+		note.Version = req.Version + 1
+
+		// 更新标签关联
+		if len(req.TagIDs) > 0 {
+			var tags []models.Tag
+			if err := tx.Where("id IN ? AND user_id = ?", req.TagIDs, note.UserID).Find(&tags).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&note).Association("Tags").Replace(tags); err != nil {
+				return err
+			}
+		}
+
+		// 标题改变时改写其他笔记中指向旧标题的链接
+		if strings.TrimSpace(oldTitle) != strings.TrimSpace(note.Title) {
+			var err error
+			if relinked, err = relinkRenamedNote(tx, &note, oldTitle, userID); err != nil {
+				return err
+			}
+		}
+
+		// 更新双链
+		if err := syncNoteLinks(tx, &note); err != nil {
+			return err
+		}
+
+		// 记录修订版本
+		return recordRevision(tx, &note, userID)
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// 重新加载笔记以获取完整数据
+	role := note.Role
 	if err := h.db.Where("id = ?", note.ID).Preload("Tags").Preload("Category").First(&note).Error; err != nil {
+		return nil, nil, err
+	}
+	note.Role = role
+	return &note, relinked, nil
+}
+
+// writeConflict 返回 409，附带服务器上的笔记以及以客户端所持版本为基准的三方合并结果
+func (h *NoteHandler) writeConflict(ctx iris.Context, current *models.Note, req NoteRequest) {
+	if err := h.db.Where("id = ?", current.ID).Preload("Tags").Preload("Category").First(current).Error; err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(iris.Map{"error": "Failed to load note"})
 		return
 	}
 
-	ctx.JSON(iris.Map{
-		"message": "Note updated successfully",
-		"note":    note,
-	})
+	response := iris.Map{
+		"error":          "Note has been modified by someone else",
+		"client_version": req.Version,
+		"note":           current,
+		"merge":          nil,
+	}
+
+	var base models.NoteRevision
+	if err := h.db.Where("note_id = ? AND version = ?", current.ID, req.Version).Order("number desc").First(&base).Error; err == nil {
+		content := utils.Merge3(base.Content, req.Content, current.Content)
+		title, titleConflict := mergeTitle(base.Title, req.Title, current.Title)
+		response["merge"] = iris.Map{
+			"base_version":   base.Version,
+			"title":          title,
+			"title_conflict": titleConflict,
+			"content":        content.Content,
+			"conflicts":      content.Conflicts,
+			"clean":          content.Conflicts == 0 && !titleConflict,
+		}
+	}
+
+	ctx.Header("ETag", noteETag(current))
+	ctx.StatusCode(iris.StatusConflict)
+	ctx.JSON(response)
+}
+
+// mergeTitle 三方合并标题，两边都修改且不相同时以客户端为准并标记冲突
+func mergeTitle(base, ours, theirs string) (string, bool) {
+	switch {
+	case ours == base:
+		return theirs, false
+	case theirs == base, ours == theirs:
+		return ours, false
+	}
+	return ours, true
+}
+
+// 笔记正文的返回格式，默认只返回 Markdown
+const (
+	formatMarkdown = "markdown"
+	formatHTML     = "html"
+)
+
+var errInvalidFormat = errors.New("invalid format")
+
+// noteFormat 读取 format 查询参数
+func noteFormat(ctx iris.Context) (string, error) {
+	switch format := ctx.URLParamDefault("format", formatMarkdown); format {
+	case formatMarkdown, formatHTML:
+		return format, nil
+	default:
+		return "", errInvalidFormat
+	}
+}
+
+// noteETag 笔记版本对应的 ETag
+func noteETag(note *models.Note) string {
+	return strconv.Quote(strconv.Itoa(note.Version))
 }
 
-// DeleteNote 删除笔记
+// parseETag 解析 If-Match 中的版本号，兼容弱 ETag 和不带引号的写法
+func parseETag(value string) (int, bool) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+	value = strings.Trim(value, `"`)
+	version, err := strconv.Atoi(value)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// DeleteNote 删除笔记（移入回收站）
 func (h *NoteHandler) DeleteNote(ctx iris.Context) {
 	id := ctx.Params().Get("id")
 	if id == "" {
@@ -232,28 +441,34 @@ func (h *NoteHandler) DeleteNote(ctx iris.Context) {
 	// 开始事务
 	tx := h.db.Begin()
 
-	// 先检查笔记是否存在
-	var note models.Note
-	if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&note).Error; err != nil {
+	// 先检查笔记是否存在，只有所有者可以删除
+	note, err := accessibleNote(tx, userID, id, models.RoleOwner)
+	if err != nil {
 		tx.Rollback()
-		if err == gorm.ErrRecordNotFound {
-			ctx.StatusCode(iris.StatusNotFound)
-			ctx.JSON(iris.Map{"error": "Note not found"})
-			return
-		}
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": "Failed to fetch note"})
+		writeNoteAccessError(ctx, err)
 		return
 	}
 
-	// 删除笔记
-	if err := tx.Delete(&note).Error; err != nil {
+	// 移入回收站，永久删除由回收站负责
+	if err := tx.Delete(note).Error; err != nil {
 		tx.Rollback()
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(iris.Map{"error": "Failed to delete note"})
 		return
 	}
 
+	// 标记仍然链接到该笔记的笔记
+	linking, err := linkingNotes(tx, userID, note.ID)
+	if err == nil {
+		err = refreshBrokenLinks(tx, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to update note links"})
+		return
+	}
+
 	// 提交事务
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
@@ -262,48 +477,73 @@ func (h *NoteHandler) DeleteNote(ctx iris.Context) {
 		return
 	}
 
+	if linking == nil {
+		linking = []LinkSource{}
+	}
+
 	ctx.JSON(iris.Map{
-		"message": "Note deleted successfully",
+		"message":       "Note moved to trash",
+		"linking_notes": linking,
 	})
 }
 
-// SearchNotes 搜索笔记
+// SearchNotes 搜索笔记，q 支持结构化查询语法（见 search.ParseQuery），分页、排序和时间过滤参数与 GetNotes 相同，
+// 使用全文索引时默认按相关度排序。响应中的 query 为解析后的查询树。
+// include_tag_descendants=true 时 tag_ids 和 tag: 同时匹配子标签，例如 lang 匹配 lang/go
 func (h *NoteHandler) SearchNotes(ctx iris.Context) {
 	userID := ctx.Values().Get("userID").(uint)
 
 	// 获取搜索参数
-	query := ctx.URLParam("q")
 	categoryID := ctx.URLParam("category_id")
 	tagIDs := ctx.URLParamSlice("tag_ids")
+	includeDescendants := ctx.URLParamBoolDefault("include_descendants", false)
+	includeTagDescendants := ctx.URLParamBoolDefault("include_tag_descendants", false)
+
+	parsed, ok := parseSearchQuery(ctx)
+	if !ok {
+		return
+	}
 
 	// 构建查询
-	db := h.db.Where("user_id = ?", userID)
+	db, match := applySearchQuery(h.db.Where("notes.user_id = ?", userID), userID, parsed, includeDescendants, includeTagDescendants)
 
-	// 关键词搜索
-	if query != "" {
-		db = db.Where("title LIKE ? OR content LIKE ?", "%"+query+"%", "%"+query+"%")
+	opts, err := parseNoteListOptions(ctx, match != "")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": err.Error()})
+		return
 	}
 
 	// 分类筛选
 	if categoryID != "" {
-		db = db.Where("category_id = ?", categoryID)
+		db = filterCategory(db, userID, categoryID, includeDescendants)
 	}
 
-	// 标签筛选
-	if len(tagIDs) > 0 {
-		for _, tagID := range tagIDs {
-			db = db.Joins("JOIN note_tags ON note_tags.note_id = notes.id").
-				Where("note_tags.tag_id = ?", tagID)
+	// 标签筛选，需要同时包含所有指定的标签（或其子标签）
+	for _, tagID := range tagIDs {
+		ids := []string{tagID}
+		if includeTagDescendants {
+			if ids, err = tagSubtree(h.db, userID, tagID); err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.JSON(iris.Map{"error": "Failed to search notes"})
+				return
+			}
 		}
+		db = db.Where("EXISTS (SELECT 1 FROM note_tags WHERE note_tags.note_id = notes.id AND note_tags.tag_id IN ?)", ids)
 	}
 
-	// 执行查询
-	var notes []models.Note
-	if err := db.Preload("Tags").Preload("Category").Find(&notes).Error; err != nil {
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": "Failed to search notes"})
+	if match != "" {
+		h.listSearchHits(ctx, db, opts, match, parsed)
 		return
 	}
+	h.listNotes(ctx, db, opts, parsed)
+}
 
-	ctx.JSON(notes)
+// ParseSearchQuery 解析搜索查询并返回查询树，供界面在输入时渲染过滤条件
+func (h *NoteHandler) ParseSearchQuery(ctx iris.Context) {
+	parsed, ok := parseSearchQuery(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(parsed)
 }
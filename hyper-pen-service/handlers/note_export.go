@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"archive/zip"
+	"fmt"
+	"hyper-pen-service/models"
+	"hyper-pen-service/utils"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+// 导出格式
+const (
+	ExportFormatMarkdown = "markdown"
+	ExportFormatJSON     = "json"
+)
+
+// ExportNote 导出单个笔记，format=markdown 时为带头信息的 .md 文件，format=json 时为 JSON
+func (h *NoteHandler) ExportNote(ctx iris.Context) {
+	id := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	format, ok := exportFormat(ctx)
+	if !ok {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Unsupported export format"})
+		return
+	}
+
+	note, err := accessibleNote(h.db.Preload("Tags").Preload("Category"), userID, id, models.RoleViewer)
+	if err != nil {
+		writeNoteAccessError(ctx, err)
+		return
+	}
+	note.Role = ""
+
+	if note.Category != nil {
+		paths, err := categoryPaths(h.db, note.Category.UserID)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "Failed to export note"})
+			return
+		}
+		note.Category.Path = joinCategoryPath(paths[note.Category.ID])
+	}
+
+	if format == ExportFormatJSON {
+		setAttachmentHeader(ctx, exportFileName(note.Title)+".json")
+		ctx.JSON(note)
+		return
+	}
+
+	data, err := noteMarkdown(note)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to export note"})
+		return
+	}
+
+	setAttachmentHeader(ctx, exportFileName(note.Title)+".md")
+	ctx.ContentType("text/markdown; charset=utf-8")
+	ctx.Write(data)
+}
+
+// ExportNotes 导出当前用户的全部笔记，format=markdown 时为按分类层级分目录的 zip 包，format=json 时为 JSON 数组
+func (h *NoteHandler) ExportNotes(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	format, ok := exportFormat(ctx)
+	if !ok {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Unsupported export format"})
+		return
+	}
+
+	var notes []models.Note
+	if err := h.db.Where("user_id = ?", userID).Order("created_at asc").Preload("Tags").Preload("Category").Find(&notes).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch notes"})
+		return
+	}
+	paths, err := categoryPaths(h.db, userID)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch notes"})
+		return
+	}
+	for i := range notes {
+		if notes[i].Category != nil {
+			notes[i].Category.Path = joinCategoryPath(paths[notes[i].Category.ID])
+		}
+	}
+
+	if format == ExportFormatJSON {
+		setAttachmentHeader(ctx, "notes.json")
+		ctx.JSON(notes)
+		return
+	}
+
+	setAttachmentHeader(ctx, "notes.zip")
+	ctx.ContentType("application/zip")
+
+	archive := zip.NewWriter(ctx.ResponseWriter())
+	used := make(map[string]bool)
+	for i := range notes {
+		data, err := noteMarkdown(&notes[i])
+		if err != nil {
+			ctx.Application().Logger().Errorf("导出笔记失败 %s: %v", notes[i].ID, err)
+			continue
+		}
+
+		// 按分类层级分目录
+		folder := ""
+		if notes[i].Category != nil {
+			for _, name := range paths[notes[i].Category.ID] {
+				folder = path.Join(folder, exportFileName(name))
+			}
+		}
+		name := uniqueExportPath(used, folder, exportFileName(notes[i].Title))
+
+		w, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: notes[i].UpdatedAt,
+		})
+		if err != nil {
+			ctx.Application().Logger().Errorf("写入导出文件失败: %v", err)
+			return
+		}
+		if _, err := w.Write(data); err != nil {
+			ctx.Application().Logger().Errorf("写入导出文件失败: %v", err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		ctx.Application().Logger().Errorf("写入导出文件失败: %v", err)
+	}
+}
+
+// exportFormat 解析 format 参数，默认为 markdown
+func exportFormat(ctx iris.Context) (string, bool) {
+	switch strings.ToLower(ctx.URLParamDefault("format", ExportFormatMarkdown)) {
+	case "markdown", "md":
+		return ExportFormatMarkdown, true
+	case "json":
+		return ExportFormatJSON, true
+	}
+	return "", false
+}
+
+// noteMarkdown 生成带 YAML 头信息的 Markdown 文本，格式与导入接口一致。分类写入 note.Category.Path
+func noteMarkdown(note *models.Note) ([]byte, error) {
+	fm := utils.FrontMatter{
+		ID:        note.ID,
+		Title:     note.Title,
+		CreatedAt: note.CreatedAt,
+		UpdatedAt: note.UpdatedAt,
+	}
+	if note.Category != nil {
+		fm.Category = note.Category.Path
+	}
+	for _, tag := range note.Tags {
+		fm.Tags = append(fm.Tags, tag.Name)
+	}
+	return utils.RenderFrontMatter(&fm, note.Content)
+}
+
+// exportFileName 将标题转换为可用的文件名
+func exportFileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(title))
+
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		name = "untitled"
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	return name
+}
+
+// uniqueExportPath 生成 zip 中不重复的文件路径
+func uniqueExportPath(used map[string]bool, folder, name string) string {
+	candidate := path.Join(folder, name+".md")
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		candidate = path.Join(folder, fmt.Sprintf("%s (%d).md", name, i))
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// setAttachmentHeader 设置下载文件名，兼容非 ASCII 字符
+func setAttachmentHeader(ctx iris.Context, fileName string) {
+	fallback := strings.Map(func(r rune) rune {
+		if r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, fileName)
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, url.PathEscape(fileName)))
+}
@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hyper-pen-service/models"
+	"hyper-pen-service/utils"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxImportSize 上传文件的大小上限
+	maxImportSize = 32 << 20
+	// maxImportEntrySize zip 中单个文件解压后的大小上限
+	maxImportEntrySize = 8 << 20
+	// maxImportEntries zip 中最多处理的文件数
+	maxImportEntries = 2000
+)
+
+// 导入结果状态
+const (
+	ImportStatusCreated = "created"
+	ImportStatusSkipped = "skipped"
+	ImportStatusFailed  = "failed"
+)
+
+// ImportResult 单个文件（或 JSON 中单条笔记）的导入结果
+type ImportResult struct {
+	File   string `json:"file"`
+	Status string `json:"status"`
+	NoteID string `json:"note_id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// importedNote 从导入文件中解析出的笔记
+type importedNote struct {
+	ID         string
+	Title      string
+	Content    string
+	Category   string // 以 / 分隔的分类路径，见 joinCategoryPath
+	CategoryID string
+	Tags       []string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+var errNoteExists = errors.New("note already exists")
+
+// ImportNotes 导入笔记，支持 Markdown、JSON 数组以及 Markdown 文件组成的 zip 包
+func (h *NoteHandler) ImportNotes(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	ctx.SetMaxRequestBodySize(maxImportSize)
+	file, header, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Invalid upload"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Failed to read upload"})
+		return
+	}
+
+	var results []ImportResult
+	switch strings.ToLower(path.Ext(header.Filename)) {
+	case ".md", ".markdown":
+		results = append(results, h.importMarkdown(userID, header.Filename, "", data))
+	case ".json":
+		results = append(results, h.importJSON(userID, header.Filename, data)...)
+	case ".zip":
+		zipResults, err := h.importZip(userID, data)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(iris.Map{"error": "Invalid zip archive"})
+			return
+		}
+		results = append(results, zipResults...)
+	default:
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Unsupported file type"})
+		return
+	}
+
+	summary := iris.Map{ImportStatusCreated: 0, ImportStatusSkipped: 0, ImportStatusFailed: 0}
+	for _, result := range results {
+		summary[result.Status] = summary[result.Status].(int) + 1
+	}
+
+	ctx.JSON(iris.Map{
+		"message": "Import finished",
+		"summary": summary,
+		"results": results,
+	})
+}
+
+// importZip 导入 zip 包中的所有 Markdown 和 JSON 文件，没有分类信息的笔记按所在目录的层级作为分类
+func (h *NoteHandler) importZip(userID uint, data []byte) ([]ImportResult, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ImportResult
+	count := 0
+	for _, entry := range reader.File {
+		name := entry.Name
+		if entry.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+
+		ext := strings.ToLower(path.Ext(name))
+		if ext != ".md" && ext != ".markdown" && ext != ".json" {
+			results = append(results, ImportResult{File: name, Status: ImportStatusSkipped, Error: "unsupported file type"})
+			continue
+		}
+
+		count++
+		if count > maxImportEntries {
+			results = append(results, ImportResult{File: name, Status: ImportStatusSkipped, Error: "too many files in archive"})
+			continue
+		}
+
+		content, err := readZipEntry(entry)
+		if err != nil {
+			results = append(results, ImportResult{File: name, Status: ImportStatusFailed, Error: err.Error()})
+			continue
+		}
+
+		if ext == ".json" {
+			results = append(results, h.importJSON(userID, name, content)...)
+			continue
+		}
+
+		folder := ""
+		if dir := path.Dir(name); dir != "." {
+			folder = joinCategoryPath(strings.Split(dir, "/"))
+		}
+		results = append(results, h.importMarkdown(userID, name, folder, content))
+	}
+
+	return results, nil
+}
+
+// readZipEntry 读取 zip 中的单个文件并限制解压后的大小
+func readZipEntry(entry *zip.File) ([]byte, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(io.LimitReader(rc, maxImportEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxImportEntrySize {
+		return nil, errors.New("file too large")
+	}
+	return content, nil
+}
+
+// importMarkdown 导入单个 Markdown 文件
+func (h *NoteHandler) importMarkdown(userID uint, fileName, folder string, data []byte) ImportResult {
+	fm, body, err := utils.ParseFrontMatter(data)
+	if err != nil {
+		return ImportResult{File: fileName, Status: ImportStatusFailed, Error: "invalid front matter: " + err.Error()}
+	}
+
+	in := importedNote{
+		ID:        fm.ID,
+		Title:     fm.Title,
+		Content:   body,
+		Category:  fm.Category,
+		Tags:      fm.Tags,
+		CreatedAt: fm.CreatedAt,
+		UpdatedAt: fm.UpdatedAt,
+	}
+	if in.Title == "" {
+		in.Title = markdownTitle(body, fileName)
+	}
+	if in.Category == "" {
+		in.Category = folder
+	}
+
+	return h.importOne(userID, fileName, in)
+}
+
+// importJSON 导入 models.Note 组成的 JSON 数组，也接受单个笔记对象
+func (h *NoteHandler) importJSON(userID uint, fileName string, data []byte) []ImportResult {
+	var notes []models.Note
+	if err := json.Unmarshal(data, &notes); err != nil {
+		var note models.Note
+		if err := json.Unmarshal(data, &note); err != nil {
+			return []ImportResult{{File: fileName, Status: ImportStatusFailed, Error: "invalid JSON"}}
+		}
+		notes = []models.Note{note}
+	}
+
+	results := make([]ImportResult, 0, len(notes))
+	for i, note := range notes {
+		in := importedNote{
+			ID:         note.ID,
+			Title:      note.Title,
+			Content:    note.Content,
+			CategoryID: note.CategoryID,
+			CreatedAt:  note.CreatedAt,
+			UpdatedAt:  note.UpdatedAt,
+		}
+		if note.Category != nil {
+			in.Category = note.Category.Path
+			if in.Category == "" {
+				in.Category = joinCategoryPath([]string{note.Category.Name})
+			}
+		}
+		for _, tag := range note.Tags {
+			in.Tags = append(in.Tags, tag.Name)
+		}
+
+		results = append(results, h.importOne(userID, fmt.Sprintf("%s[%d]", fileName, i), in))
+	}
+	return results
+}
+
+// importOne 在独立事务中创建一条导入的笔记
+func (h *NoteHandler) importOne(userID uint, fileName string, in importedNote) ImportResult {
+	result := ImportResult{File: fileName, Title: in.Title}
+
+	if strings.TrimSpace(in.Title) == "" && strings.TrimSpace(in.Content) == "" {
+		result.Status = ImportStatusSkipped
+		result.Error = "empty note"
+		return result
+	}
+
+	note, err := h.createImportedNote(userID, in)
+	if err == errNoteExists {
+		result.Status = ImportStatusSkipped
+		result.NoteID = in.ID
+		result.Error = err.Error()
+		return result
+	}
+	if err != nil {
+		result.Status = ImportStatusFailed
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Status = ImportStatusCreated
+	result.NoteID = note.ID
+	return result
+}
+
+// createImportedNote 创建笔记，并按名称匹配或创建分类和标签
+func (h *NoteHandler) createImportedNote(userID uint, in importedNote) (*models.Note, error) {
+	note := models.Note{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Title:     in.Title,
+		Content:   in.Content,
+		Version:   1,
+		CreatedAt: in.CreatedAt,
+		UpdatedAt: in.UpdatedAt,
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// 保留原有ID以便重复导入时跳过用户已有的笔记；ID 被回收站中的笔记或其他用户占用时使用新ID
+		if _, err := uuid.Parse(in.ID); err == nil {
+			var count int64
+			if err := tx.Model(&models.Note{}).Where("id = ? AND user_id = ?", in.ID, userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return errNoteExists
+			}
+			if err := tx.Unscoped().Model(&models.Note{}).Where("id = ?", in.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				note.ID = in.ID
+			}
+		}
+
+		if names := splitCategoryPath(in.Category); len(names) > 0 {
+			categoryID, err := findOrCreateCategoryPath(tx, userID, names)
+			if err != nil {
+				return err
+			}
+			note.CategoryID = categoryID
+		} else if in.CategoryID != "" {
+			var count int64
+			if err := tx.Model(&models.Category{}).Where("id = ? AND user_id = ?", in.CategoryID, userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				note.CategoryID = in.CategoryID
+			}
+		}
+
+		if err := tx.Create(&note).Error; err != nil {
+			return err
+		}
+
+		if len(in.Tags) > 0 {
+			tags, err := findOrCreateTags(tx, userID, in.Tags)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&note).Association("Tags").Replace(tags); err != nil {
+				return err
+			}
+		}
+		return syncNoteLinks(tx, &note)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+// findOrCreateCategoryPath 从顶级分类开始逐级在上级分类下按名称查找用户的分类，不存在时创建，返回最后一级分类的ID
+func findOrCreateCategoryPath(tx *gorm.DB, userID uint, names []string) (string, error) {
+	parentID := ""
+	for _, name := range names {
+		var category models.Category
+		err := tx.Where("user_id = ? AND name = ? AND COALESCE(parent_id, '') = ?", userID, name, parentID).
+			Order("created_at asc").First(&category).Error
+		if err == gorm.ErrRecordNotFound {
+			category = models.Category{
+				ID:       uuid.New().String(),
+				Name:     name,
+				ParentID: parentID,
+				UserID:   userID,
+			}
+			err = tx.Create(&category).Error
+		}
+		if err != nil {
+			return "", err
+		}
+		parentID = category.ID
+	}
+	return parentID, nil
+}
+
+// findOrCreateTags 按名称（不区分大小写）查找用户的标签，不存在时创建
+func findOrCreateTags(tx *gorm.DB, userID uint, names []string) ([]models.Tag, error) {
+	var tags []models.Tag
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = models.NormalizeTagName(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		var tag models.Tag
+		err := tx.Where("user_id = ? AND LOWER(name) = LOWER(?)", userID, name).First(&tag).Error
+		if err == gorm.ErrRecordNotFound {
+			tag = models.Tag{
+				ID:     uuid.New().String(),
+				Name:   name,
+				Color:  defaultTagColor,
+				UserID: userID,
+			}
+			err = tx.Create(&tag).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// markdownTitle 从正文的第一个一级标题推断标题，没有时使用文件名
+func markdownTitle(body, fileName string) string {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "# ") {
+			return strings.TrimSpace(line[2:])
+		}
+	}
+	base := path.Base(fileName)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"errors"
+	"hyper-pen-service/models"
+	"hyper-pen-service/utils"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// Backlink 链接到某篇笔记的来源笔记
+type Backlink struct {
+	NoteID    string    `json:"note_id"`
+	Title     string    `json:"title"`
+	Alias     string    `json:"alias,omitempty"`
+	Context   string    `json:"context"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UnresolvedLink 目标笔记不存在的链接，按目标分组
+type UnresolvedLink struct {
+	Target  string       `json:"target"`
+	Count   int          `json:"count"`
+	Sources []LinkSource `json:"sources"`
+}
+
+// LinkSource 包含链接的笔记
+type LinkSource struct {
+	NoteID string `json:"note_id"`
+	Title  string `json:"title"`
+}
+
+// GraphNode 笔记关系图中的节点
+type GraphNode struct {
+	ID         string       `json:"id"`
+	Title      string       `json:"title"`
+	CategoryID string       `json:"category_id"`
+	Tags       []models.Tag `json:"tags"`
+}
+
+// RelinkedNote 因目标笔记改名而被改写链接的笔记
+type RelinkedNote struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Version int    `json:"version"`
+	Links   int    `json:"links"`
+}
+
+// GraphEdge 笔记关系图中的边，由 Source 链接到 Target
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// syncNoteLinks 根据笔记正文重建其发出的链接，更新其他笔记中按标题指向本笔记的链接，并刷新失效链接标记。
+// 只处理受本次保存影响的链接和笔记，保存的开销与用户的笔记总数无关
+func syncNoteLinks(tx *gorm.DB, note *models.Note) error {
+	if err := writeNoteLinks(tx, note); err != nil {
+		return err
+	}
+	affected := []string{note.ID}
+	targets := []string{note.ID, note.Title}
+
+	// 标题改变后，按旧标题链接到本笔记的链接重新解析
+	var renamed []models.NoteLink
+	if err := tx.Select("id", "source_id", "target_title").
+		Where("user_id = ? AND target_id = ? AND target_title <> ? AND LOWER(target_title) <> LOWER(?)", note.UserID, note.ID, note.ID, note.Title).
+		Find(&renamed).Error; err != nil {
+		return err
+	}
+	if len(renamed) > 0 {
+		ids := make([]uint, len(renamed))
+		for i, link := range renamed {
+			ids[i] = link.ID
+			affected = append(affected, link.SourceID)
+			targets = append(targets, link.TargetTitle)
+		}
+		if err := tx.Model(&models.NoteLink{}).Where("id IN ?", ids).Update("target_id", "").Error; err != nil {
+			return err
+		}
+	}
+
+	// 只有指向本笔记ID、新标题或旧标题的未解析链接可能因本次保存而解析
+	// 与 linkTargetSQL 一样在 SQL 中比较，大小写规则保持一致
+	match := tx.Where("LOWER(target_title) = LOWER(?)", targets[0])
+	for _, target := range targets[1:] {
+		match = match.Or("LOWER(target_title) = LOWER(?)", target)
+	}
+	var dangling []models.NoteLink
+	if err := tx.Select("id", "source_id").
+		Where("user_id = ? AND target_id = ''", note.UserID).Where(match).
+		Find(&dangling).Error; err != nil {
+		return err
+	}
+	if len(dangling) > 0 {
+		ids := make([]uint, len(dangling))
+		for i, link := range dangling {
+			ids[i] = link.ID
+			affected = append(affected, link.SourceID)
+		}
+		if err := tx.Exec(`UPDATE note_links SET target_id = (`+linkTargetSQL+` ORDER BY notes.updated_at DESC LIMIT 1)
+			WHERE id IN ? AND EXISTS (`+linkTargetSQL+`)`, ids).Error; err != nil {
+			return err
+		}
+	}
+	return refreshNotesBrokenLinks(tx, affected)
+}
+
+// writeNoteLinks 根据笔记正文重建其发出的链接
+func writeNoteLinks(tx *gorm.DB, note *models.Note) error {
+	if err := tx.Where("source_id = ?", note.ID).Delete(&models.NoteLink{}).Error; err != nil {
+		return err
+	}
+
+	var links []models.NoteLink
+	for _, link := range utils.ParseWikiLinks(note.Content) {
+		targetID, err := resolveLinkTarget(tx, note.UserID, link.Target)
+		if err != nil {
+			return err
+		}
+		links = append(links, models.NoteLink{
+			UserID:      note.UserID,
+			SourceID:    note.ID,
+			TargetID:    targetID,
+			TargetTitle: link.Target,
+			Alias:       link.Alias,
+		})
+	}
+	if len(links) > 0 {
+		return tx.Create(&links).Error
+	}
+	return nil
+}
+
+// brokenLinksSQL 笔记是否包含目标不存在或在回收站中的链接
+const brokenLinksSQL = `UPDATE notes SET has_broken_links = EXISTS (
+			SELECT 1 FROM note_links
+			LEFT JOIN notes targets ON targets.id = note_links.target_id AND targets.deleted_at IS NULL
+			WHERE note_links.source_id = notes.id AND targets.id IS NULL)`
+
+// refreshBrokenLinks 重新计算用户所有笔记的失效链接标记
+func refreshBrokenLinks(tx *gorm.DB, userID uint) error {
+	return tx.Exec(brokenLinksSQL+` WHERE user_id = ?`, userID).Error
+}
+
+// refreshNotesBrokenLinks 重新计算指定笔记的失效链接标记
+func refreshNotesBrokenLinks(tx *gorm.DB, noteIDs []string) error {
+	return tx.Exec(brokenLinksSQL+` WHERE id IN ?`, noteIDs).Error
+}
+
+// relinkRenamedNote 把其他笔记中按旧标题指向 note 的链接改写为新标题，新标题无法写入链接时改用笔记ID。
+// 只改写 editorID 有编辑权限的笔记，其余笔记中的链接保留旧标题并成为未解析链接。
+// 被改写的笔记会升级版本并记录由 editorID 保存的修订版本，返回被改写的笔记
+func relinkRenamedNote(tx *gorm.DB, note *models.Note, oldTitle string, editorID uint) ([]RelinkedNote, error) {
+	var sourceIDs []string
+	if err := tx.Model(&models.NoteLink{}).
+		Where("user_id = ? AND target_id = ? AND source_id <> ? AND LOWER(target_title) = LOWER(?)", note.UserID, note.ID, note.ID, oldTitle).
+		Distinct().Pluck("source_id", &sourceIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(sourceIDs) == 0 {
+		return nil, nil
+	}
+
+	newTarget, defaultAlias := strings.TrimSpace(note.Title), ""
+	if !utils.ValidWikiLinkTarget(note.Title) {
+		newTarget = note.ID
+		defaultAlias = strings.NewReplacer("[", "", "]", "", "|", "", "\n", " ").Replace(strings.TrimSpace(note.Title))
+	}
+
+	// 回收站中的笔记不会被改写
+	var sources []models.Note
+	if err := tx.Where("id IN ?", sourceIDs).Order("title").Find(&sources).Error; err != nil {
+		return nil, err
+	}
+
+	var relinked []RelinkedNote
+	for i := range sources {
+		source := &sources[i]
+		if source.UserID != editorID {
+			if _, err := accessibleNote(tx, editorID, source.ID, models.RoleEditor); err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, errNoteForbidden) {
+					continue
+				}
+				return nil, err
+			}
+		}
+		content, n := utils.RenameWikiLinks(source.Content, oldTitle, newTarget, defaultAlias)
+		if n == 0 {
+			continue
+		}
+
+		if err := ensureRevisionBaseline(tx, source); err != nil {
+			return nil, err
+		}
+		version := source.Version + 1
+		if err := tx.Model(source).Updates(map[string]interface{}{
+			"content": content,
+			"version": version,
+		}).Error; err != nil {
+			return nil, err
+		}
+		source.Content = content
+		source.Version = version
+		if err := recordRevision(tx, source, editorID); err != nil {
+			return nil, err
+		}
+		if err := writeNoteLinks(tx, source); err != nil {
+			return nil, err
+		}
+
+		relinked = append(relinked, RelinkedNote{ID: source.ID, Title: source.Title, Version: source.Version, Links: n})
+	}
+	return relinked, nil
+}
+
+// linkingNotes 获取仍然链接到指定笔记的其他笔记
+func linkingNotes(tx *gorm.DB, userID uint, noteID string) ([]LinkSource, error) {
+	var sources []LinkSource
+	err := tx.Model(&models.Note{}).
+		Select("notes.id AS note_id, notes.title").
+		Where("notes.user_id = ? AND notes.id <> ? AND notes.id IN (?)", userID, noteID,
+			tx.Model(&models.NoteLink{}).Select("source_id").Where("target_id = ?", noteID)).
+		Order("notes.title").
+		Scan(&sources).Error
+	return sources, err
+}
+
+// RebuildNoteLinks 根据所有笔记的正文重建双链，用于首次启用双链时回填已有笔记
+func RebuildNoteLinks(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var notes []models.Note
+		if err := tx.Select("id", "user_id", "title", "content").Find(&notes).Error; err != nil {
+			return err
+		}
+		users := make(map[uint]bool)
+		for i := range notes {
+			if err := writeNoteLinks(tx, &notes[i]); err != nil {
+				return err
+			}
+			users[notes[i].UserID] = true
+		}
+		for userID := range users {
+			if err := refreshBrokenLinks(tx, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// resolveLinkTarget 按笔记ID或标题（不区分大小写）查找链接目标，同名笔记取最近更新的一篇，找不到时返回空字符串
+func resolveLinkTarget(tx *gorm.DB, userID uint, target string) (string, error) {
+	var ids []string
+	query := tx.Model(&models.Note{}).Where("user_id = ?", userID)
+	if _, err := uuid.Parse(target); err == nil {
+		query = query.Where("id = ?", target)
+	} else {
+		query = query.Where("LOWER(title) = LOWER(?)", target).Order("updated_at desc")
+	}
+	if err := query.Limit(1).Pluck("id", &ids).Error; err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", nil
+	}
+	return ids[0], nil
+}
+
+// linkTargetSQL 与 note_links 中的链接匹配的目标笔记（按ID或不区分大小写的标题）
+const linkTargetSQL = `SELECT notes.id FROM notes
+			WHERE notes.user_id = note_links.user_id AND notes.deleted_at IS NULL
+				AND (notes.id = note_links.target_title OR LOWER(notes.title) = LOWER(note_links.target_title))`
+
+// resolveDanglingLinks 为用户尚未解析的链接查找目标笔记
+func resolveDanglingLinks(tx *gorm.DB, userID uint) error {
+	return tx.Exec(`UPDATE note_links SET target_id = (`+linkTargetSQL+` ORDER BY notes.updated_at DESC LIMIT 1)
+		WHERE user_id = ? AND target_id = '' AND EXISTS (`+linkTargetSQL+`)`, userID).Error
+}
+
+// GetBacklinks 获取链接到指定笔记的其他笔记
+func (h *NoteHandler) GetBacklinks(ctx iris.Context) {
+	note, ok := h.findAccessibleNote(ctx, models.RoleViewer)
+	if !ok {
+		return
+	}
+	userID := ctx.Values().Get("userID").(uint)
+
+	// 协作者只能看到自己也有权限访问的来源笔记
+	var rows []struct {
+		NoteID      string
+		Title       string
+		Content     string
+		Alias       string
+		TargetTitle string
+		UpdatedAt   time.Time
+	}
+	if err := h.db.Table("note_links").
+		Select("notes.id AS note_id, notes.title, notes.content, notes.updated_at, note_links.alias, note_links.target_title").
+		Joins("JOIN notes ON notes.id = note_links.source_id AND notes.deleted_at IS NULL").
+		Where("note_links.target_id = ? AND note_links.user_id = ?", note.ID, note.UserID).
+		Where("(notes.user_id = ? OR "+sharedNoteCondition+")", userID, userID, userID).
+		Order("notes.updated_at desc").
+		Scan(&rows).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch backlinks"})
+		return
+	}
+
+	// 同一篇笔记可能以标题和ID多次链接到目标，只保留一条
+	backlinks := make([]Backlink, 0, len(rows))
+	seen := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		if seen[row.NoteID] {
+			continue
+		}
+		seen[row.NoteID] = true
+		backlinks = append(backlinks, Backlink{
+			NoteID:    row.NoteID,
+			Title:     row.Title,
+			Alias:     row.Alias,
+			Context:   linkContext(row.Content, row.TargetTitle),
+			UpdatedAt: row.UpdatedAt,
+		})
+	}
+	ctx.JSON(backlinks)
+}
+
+// linkContext 返回正文中第一处包含该链接的行
+func linkContext(content, target string) string {
+	needle := "[[" + strings.ToLower(target)
+	for _, line := range strings.Split(content, "\n") {
+		if strings.Contains(strings.ToLower(line), needle) {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// GetUnresolvedLinks 获取目标笔记不存在（或在回收站中）的链接
+func (h *NoteHandler) GetUnresolvedLinks(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	var rows []struct {
+		TargetTitle string
+		NoteID      string
+		Title       string
+	}
+	if err := h.db.Table("note_links").
+		Select("note_links.target_title, sources.id AS note_id, sources.title").
+		Joins("JOIN notes sources ON sources.id = note_links.source_id AND sources.deleted_at IS NULL").
+		Joins("LEFT JOIN notes targets ON targets.id = note_links.target_id AND targets.deleted_at IS NULL").
+		Where("note_links.user_id = ? AND targets.id IS NULL", userID).
+		Order("sources.title").
+		Scan(&rows).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch unresolved links"})
+		return
+	}
+
+	groups := make(map[string]*UnresolvedLink)
+	var order []string
+	for _, row := range rows {
+		key := strings.ToLower(row.TargetTitle)
+		group, ok := groups[key]
+		if !ok {
+			group = &UnresolvedLink{Target: row.TargetTitle}
+			groups[key] = group
+			order = append(order, key)
+		}
+		group.Count++
+		group.Sources = append(group.Sources, LinkSource{NoteID: row.NoteID, Title: row.Title})
+	}
+
+	unresolved := make([]UnresolvedLink, 0, len(order))
+	for _, key := range order {
+		unresolved = append(unresolved, *groups[key])
+	}
+	sort.SliceStable(unresolved, func(i, j int) bool {
+		return unresolved[i].Count > unresolved[j].Count
+	})
+	ctx.JSON(unresolved)
+}
+
+// GetGraph 获取笔记关系图，可以通过 category_id 和 tag_ids（需同时包含所有标签）过滤节点，只返回两端都在图中的边
+func (h *NoteHandler) GetGraph(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	query := h.db.Model(&models.Note{}).Select("id", "title", "category_id").Where("notes.user_id = ?", userID)
+	if categoryID := ctx.URLParam("category_id"); categoryID != "" {
+		query = query.Where("notes.category_id = ?", categoryID)
+	}
+	for _, tagID := range ctx.URLParamSlice("tag_ids") {
+		query = query.Where("EXISTS (SELECT 1 FROM note_tags WHERE note_tags.note_id = notes.id AND note_tags.tag_id = ?)", tagID)
+	}
+
+	var notes []models.Note
+	if err := query.Preload("Tags").Find(&notes).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch notes"})
+		return
+	}
+
+	nodes := make([]GraphNode, 0, len(notes))
+	included := make(map[string]bool, len(notes))
+	for _, note := range notes {
+		tags := note.Tags
+		if tags == nil {
+			tags = []models.Tag{}
+		}
+		nodes = append(nodes, GraphNode{ID: note.ID, Title: note.Title, CategoryID: note.CategoryID, Tags: tags})
+		included[note.ID] = true
+	}
+
+	var links []models.NoteLink
+	if err := h.db.Select("source_id", "target_id").
+		Where("user_id = ? AND target_id <> ''", userID).Find(&links).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch links"})
+		return
+	}
+
+	edges := make([]GraphEdge, 0, len(links))
+	seen := make(map[GraphEdge]bool, len(links))
+	for _, link := range links {
+		edge := GraphEdge{Source: link.SourceID, Target: link.TargetID}
+		if included[edge.Source] && included[edge.Target] && !seen[edge] {
+			seen[edge] = true
+			edges = append(edges, edge)
+		}
+	}
+
+	ctx.JSON(iris.Map{
+		"nodes": nodes,
+		"edges": edges,
+	})
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hyper-pen-service/models"
+	"hyper-pen-service/search"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultNotePageSize = 50
+	maxNotePageSize     = 200
+)
+
+// noteSortColumns 可用的排序字段
+var noteSortColumns = map[string]string{
+	"created": "notes.created_at",
+	"updated": "notes.updated_at",
+	"title":   "notes.title",
+}
+
+// relevanceSort 按全文检索相关度排序，仅在使用全文索引搜索时可用
+const relevanceSort = "relevance"
+
+// NoteListResponse 分页的笔记列表
+type NoteListResponse struct {
+	Notes      []models.Note `json:"notes"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Total      int64         `json:"total"`
+	Query      *search.Query `json:"query,omitempty"`
+}
+
+// noteListOptions 笔记列表的分页、排序和过滤参数
+type noteListOptions struct {
+	limit          int
+	sort           string
+	desc           bool
+	cursor         *noteCursor
+	offset         int // 相关度排序无法使用键集分页，改用偏移量
+	updatedSince   *time.Time
+	createdBefore  *time.Time
+	includeContent bool
+}
+
+// noteCursor 游标，记录上一页最后一条笔记的排序字段值和ID，相关度排序时 Value 为偏移量
+type noteCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// parseNoteListOptions 解析 limit、cursor、sort、order、updated_since、created_before、include_content 参数，
+// relevance 为 true 时允许并默认按相关度排序
+func parseNoteListOptions(ctx iris.Context, relevance bool) (*noteListOptions, error) {
+	defaultSort := "created"
+	if relevance {
+		defaultSort = relevanceSort
+	}
+	opts := &noteListOptions{
+		limit:          ctx.URLParamIntDefault("limit", defaultNotePageSize),
+		sort:           ctx.URLParamDefault("sort", defaultSort),
+		desc:           true,
+		includeContent: ctx.URLParamBoolDefault("include_content", true),
+	}
+
+	if opts.limit <= 0 || opts.limit > maxNotePageSize {
+		return nil, errors.New("limit must be between 1 and 200")
+	}
+	if opts.sort == relevanceSort {
+		if !relevance {
+			return nil, errors.New("sort=relevance requires a full-text search query")
+		}
+	} else if _, ok := noteSortColumns[opts.sort]; !ok {
+		return nil, errors.New("sort must be one of created, updated, title, relevance")
+	}
+
+	switch ctx.URLParam("order") {
+	case "":
+		// 标题默认升序，时间默认降序
+		opts.desc = opts.sort != "title"
+	case "asc":
+		opts.desc = false
+	case "desc":
+		opts.desc = true
+	default:
+		return nil, errors.New("order must be asc or desc")
+	}
+
+	var err error
+	if raw := ctx.URLParam("cursor"); raw != "" {
+		cursor, err := decodeNoteCursor(raw)
+		if err != nil || cursor.Sort != opts.sort {
+			return nil, errors.New("invalid cursor")
+		}
+		opts.cursor = cursor
+		if opts.sort == relevanceSort {
+			if opts.offset, err = strconv.Atoi(cursor.Value); err != nil || opts.offset < 0 {
+				return nil, errors.New("invalid cursor")
+			}
+		}
+	}
+
+	if opts.updatedSince, err = parseTimeParam(ctx.URLParam("updated_since")); err != nil {
+		return nil, errors.New("invalid updated_since")
+	}
+	if opts.createdBefore, err = parseTimeParam(ctx.URLParam("created_before")); err != nil {
+		return nil, errors.New("invalid created_before")
+	}
+
+	return opts, nil
+}
+
+// parseTimeParam 解析 RFC3339 时间或 YYYY-MM-DD 日期，空字符串返回 nil
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// filter 应用时间过滤条件
+func (o *noteListOptions) filter(db *gorm.DB) *gorm.DB {
+	if o.updatedSince != nil {
+		db = db.Where("notes.updated_at >= ?", *o.updatedSince)
+	}
+	if o.createdBefore != nil {
+		db = db.Where("notes.created_at < ?", *o.createdBefore)
+	}
+	return db
+}
+
+// page 应用游标、排序和数量限制，多取一条用于判断是否还有下一页
+func (o *noteListOptions) page(db *gorm.DB) *gorm.DB {
+	if o.sort == relevanceSort {
+		return db.Order(search.RankColumn).Order("notes.id").Offset(o.offset).Limit(o.limit + 1)
+	}
+
+	column := noteSortColumns[o.sort]
+	direction, cmp := "asc", ">"
+	if o.desc {
+		direction, cmp = "desc", "<"
+	}
+
+	if o.cursor != nil {
+		value := o.cursorValue()
+		db = db.Where("("+column+" "+cmp+" ? OR ("+column+" = ? AND notes.id "+cmp+" ?))", value, value, o.cursor.ID)
+	}
+	return db.Order(column + " " + direction).Order("notes.id " + direction).Limit(o.limit + 1)
+}
+
+// cursorValue 将游标中的值转换为与数据库中存储格式一致的参数
+func (o *noteListOptions) cursorValue() interface{} {
+	if o.sort == "title" {
+		return o.cursor.Value
+	}
+	t, err := time.Parse(time.RFC3339Nano, o.cursor.Value)
+	if err != nil {
+		return o.cursor.Value
+	}
+	return t
+}
+
+// nextCursor 根据本页最后一条笔记生成下一页的游标
+func (o *noteListOptions) nextCursor(note *models.Note) string {
+	cursor := noteCursor{Sort: o.sort, ID: note.ID}
+	switch o.sort {
+	case relevanceSort:
+		cursor.Value = strconv.Itoa(o.offset + o.limit)
+	case "created":
+		cursor.Value = note.CreatedAt.Format(time.RFC3339Nano)
+	case "updated":
+		cursor.Value = note.UpdatedAt.Format(time.RFC3339Nano)
+	default:
+		cursor.Value = note.Title
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeNoteCursor(raw string) (*noteCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cursor noteCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// listNotes 在 query 的基础上统计总数并返回一页笔记，parsed 为搜索时解析的查询树
+func (h *NoteHandler) listNotes(ctx iris.Context, query *gorm.DB, opts *noteListOptions, parsed *search.Query) {
+	query = opts.filter(query).Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Model(&models.Note{}).Distinct("notes.id").Count(&total).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch notes"})
+		return
+	}
+
+	page := opts.page(query)
+	if !opts.includeContent {
+		page = page.Omit("content")
+	}
+
+	var notes []models.Note
+	if err := page.Preload("Tags").Preload("Category").Find(&notes).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch notes"})
+		return
+	}
+
+	response := NoteListResponse{Notes: notes, Total: total, Query: parsed}
+	if len(notes) > opts.limit {
+		response.Notes = notes[:opts.limit]
+		response.NextCursor = opts.nextCursor(&response.Notes[opts.limit-1])
+	}
+	if response.Notes == nil {
+		response.Notes = []models.Note{}
+	}
+
+	ctx.JSON(response)
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hyper-pen-service/models"
+	"hyper-pen-service/search"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// NoteSearchResult 全文检索命中的笔记，TitleHighlight 和 Snippet 为已转义的 HTML，匹配文本用 <mark> 标记
+type NoteSearchResult struct {
+	models.Note
+	Rank           float64 `json:"rank"`
+	TitleHighlight string  `json:"title_highlight"`
+	Snippet        string  `json:"snippet"`
+}
+
+// NoteSearchResponse 分页的全文检索结果
+type NoteSearchResponse struct {
+	Notes      []NoteSearchResult `json:"notes"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+	Total      int64              `json:"total"`
+	Query      *search.Query      `json:"query,omitempty"`
+}
+
+// textMatchCondition 标题或正文包含关键词
+const textMatchCondition = "(notes.title LIKE ? OR notes.content LIKE ?)"
+
+// parseSearchQuery 解析 q 参数，语法错误时返回 400 和出错位置
+func parseSearchQuery(ctx iris.Context) (*search.Query, bool) {
+	parsed, err := search.ParseQuery(ctx.URLParam("q"))
+	if err != nil {
+		var parseErr *search.ParseError
+		if errors.As(err, &parseErr) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(iris.Map{
+				"error":    "Invalid search query: " + parseErr.Message,
+				"position": parseErr.Position,
+			})
+			return nil, false
+		}
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Invalid search query"})
+		return nil, false
+	}
+	return parsed, true
+}
+
+// applySearchQuery 把解析后的查询转换为过滤条件，返回加上条件的查询和交给全文索引的匹配表达式；
+// 全文索引不可用或词太短时使用 LIKE。includeDescendants 为 true 时 category: 包含子孙分类，
+// includeTagDescendants 为 true 时 tag: 包含子标签
+func applySearchQuery(db *gorm.DB, userID uint, query *search.Query, includeDescendants, includeTagDescendants bool) (*gorm.DB, string) {
+	var texts []string
+	for _, clause := range query.TextTerms() {
+		texts = append(texts, clause.Value)
+	}
+
+	var match string
+	likeTerms := texts
+	if search.Available() {
+		match, likeTerms = search.MatchExpression(texts)
+	}
+	for _, term := range likeTerms {
+		db = db.Where(textMatchCondition, "%"+term+"%", "%"+term+"%")
+	}
+
+	for _, clause := range query.Clauses {
+		not := ""
+		if clause.Negated {
+			not = "NOT "
+		}
+
+		switch clause.Kind {
+		case search.KindTerm, search.KindPhrase:
+			if clause.Negated {
+				db = db.Where(not+textMatchCondition, "%"+clause.Value+"%", "%"+clause.Value+"%")
+			}
+		case search.KindTag:
+			if includeTagDescendants {
+				db = db.Where(not+`EXISTS (SELECT 1 FROM note_tags JOIN tags ON tags.id = note_tags.tag_id
+					WHERE note_tags.note_id = notes.id AND tags.user_id = ? AND `+tagSubtreeCondition+`)`,
+					userID, clause.Value, clause.Value, clause.Value)
+				continue
+			}
+			db = db.Where(not+`EXISTS (SELECT 1 FROM note_tags JOIN tags ON tags.id = note_tags.tag_id
+				WHERE note_tags.note_id = notes.id AND tags.user_id = ? AND LOWER(tags.name) = LOWER(?))`, userID, clause.Value)
+		case search.KindCategory:
+			categories := "SELECT id FROM categories WHERE user_id = ? AND LOWER(name) = LOWER(?)"
+			if includeDescendants {
+				categories = fmt.Sprintf(categorySubtreeSQL, "user_id = ? AND LOWER(name) = LOWER(?)")
+			}
+			db = db.Where("notes.category_id "+not+"IN ("+categories+")", userID, clause.Value)
+		case search.KindUpdated, search.KindCreated:
+			column := "notes.updated_at"
+			if clause.Kind == search.KindCreated {
+				column = "notes.created_at"
+			}
+			if clause.From != nil {
+				db = db.Where(column+" >= ?", *clause.From)
+			}
+			if clause.To != nil {
+				db = db.Where(column+" < ?", *clause.To)
+			}
+		}
+	}
+
+	return db, match
+}
+
+// listSearchHits 在 query 的基础上使用全文索引匹配 match，返回一页带相关度和片段的结果
+func (h *NoteHandler) listSearchHits(ctx iris.Context, query *gorm.DB, opts *noteListOptions, match string, parsed *search.Query) {
+	query = search.Join(opts.filter(query), match).Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Model(&models.Note{}).Distinct("notes.id").Count(&total).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to search notes"})
+		return
+	}
+
+	var hits []search.Hit
+	if err := opts.page(query.Model(&models.Note{}).Select(search.HitColumns)).Scan(&hits).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to search notes"})
+		return
+	}
+
+	more := len(hits) > opts.limit
+	if more {
+		hits = hits[:opts.limit]
+	}
+
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.NoteID)
+	}
+
+	notesQuery := h.db.Where("id IN ?", ids)
+	if !opts.includeContent {
+		notesQuery = notesQuery.Omit("content")
+	}
+	var notes []models.Note
+	if err := notesQuery.Preload("Tags").Preload("Category").Find(&notes).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to search notes"})
+		return
+	}
+	byID := make(map[string]models.Note, len(notes))
+	for _, note := range notes {
+		byID[note.ID] = note
+	}
+
+	response := NoteSearchResponse{Notes: make([]NoteSearchResult, 0, len(hits)), Total: total, Query: parsed}
+	for _, hit := range hits {
+		note, ok := byID[hit.NoteID]
+		if !ok {
+			continue
+		}
+		response.Notes = append(response.Notes, NoteSearchResult{
+			Note:           note,
+			Rank:           hit.Rank,
+			TitleHighlight: search.HighlightHTML(hit.TitleHighlight),
+			Snippet:        search.HighlightHTML(hit.Snippet),
+		})
+	}
+	if more && len(response.Notes) > 0 {
+		response.NextCursor = opts.nextCursor(&response.Notes[len(response.Notes)-1].Note)
+	}
+
+	ctx.JSON(response)
+}
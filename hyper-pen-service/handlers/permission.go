@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hyper-pen-service/models"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// categoryAncestorsSQL 查询分类及其所有上级分类的ID
+const categoryAncestorsSQL = `WITH RECURSIVE ancestors(id, parent_id) AS (
+	SELECT id, parent_id FROM categories WHERE id = ?
+	UNION
+	SELECT categories.id, categories.parent_id FROM categories JOIN ancestors ON categories.id = ancestors.parent_id
+) SELECT id FROM ancestors`
+
+// sharedNoteCondition 笔记直接共享给了用户，或者笔记所在分类（或其上级分类）共享给了用户；两个参数都是用户ID
+var sharedNoteCondition = `(EXISTS (SELECT 1 FROM permissions WHERE permissions.user_id = ? AND permissions.note_id = notes.id AND permissions.owner_id = notes.user_id)
+	OR EXISTS (SELECT 1 FROM categories WHERE categories.id = notes.category_id AND categories.user_id = notes.user_id AND categories.id IN (` +
+	fmt.Sprintf(categorySubtreeSQL, "id IN (SELECT category_id FROM permissions WHERE user_id = ? AND category_id <> '')") + `)))`
+
+// errNoteForbidden 用户可以访问笔记，但角色不足以执行该操作
+var errNoteForbidden = errors.New("insufficient permission")
+
+// noteRole 返回用户对笔记的角色：所有者为 RoleOwner，其他用户取笔记和所在分类上授予的最高角色，没有权限时返回空字符串
+func noteRole(tx *gorm.DB, userID uint, note *models.Note) (string, error) {
+	if note.UserID == userID {
+		return models.RoleOwner, nil
+	}
+
+	query := tx.Model(&models.Permission{}).Where("user_id = ? AND owner_id = ?", userID, note.UserID)
+	if note.CategoryID != "" {
+		query = query.Where("(note_id = ? OR category_id IN ("+categoryAncestorsSQL+"))", note.ID, note.CategoryID)
+	} else {
+		query = query.Where("note_id = ?", note.ID)
+	}
+	var roles []string
+	if err := query.Pluck("role", &roles).Error; err != nil {
+		return "", err
+	}
+
+	role := ""
+	for _, r := range roles {
+		if role == "" || models.RoleAtLeast(r, role) {
+			role = r
+		}
+	}
+	return role, nil
+}
+
+// accessibleNote 查找笔记并检查用户至少具有 minRole 权限。没有任何权限时返回 gorm.ErrRecordNotFound，
+// 避免泄露笔记是否存在；角色不足时返回 errNoteForbidden。db 可以带有 Preload 等条件
+func accessibleNote(db *gorm.DB, userID uint, id, minRole string) (*models.Note, error) {
+	var note models.Note
+	if err := db.Where("notes.id = ?", id).First(&note).Error; err != nil {
+		return nil, err
+	}
+	role, err := noteRole(db.Session(&gorm.Session{NewDB: true}), userID, &note)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if !models.RoleAtLeast(role, minRole) {
+		return nil, errNoteForbidden
+	}
+	note.Role = role
+	return &note, nil
+}
+
+// writeNoteAccessError 把 accessibleNote 的错误转换为响应
+func writeNoteAccessError(ctx iris.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "Note not found"})
+	case errors.Is(err, errNoteForbidden):
+		ctx.StatusCode(iris.StatusForbidden)
+		ctx.JSON(iris.Map{"error": "You do not have permission to perform this action"})
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch note"})
+	}
+}
+
+// PermissionHandler 处理把笔记或分类共享给其他用户的请求
+type PermissionHandler struct {
+	db     *gorm.DB
+	collab *CollabHandler
+}
+
+// NewPermissionHandler 创建新的权限处理器，降低或取消权限时断开协作者在 collab 中已无权编辑的连接
+func NewPermissionHandler(db *gorm.DB, collab *CollabHandler) *PermissionHandler {
+	return &PermissionHandler{db: db, collab: collab}
+}
+
+// PermissionRequest 邀请协作者的请求，User 为用户名或邮箱
+type PermissionRequest struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+// UpdatePermissionRequest 修改协作者角色的请求
+type UpdatePermissionRequest struct {
+	Role string `json:"role"`
+}
+
+// Collaborator 返回给其他用户的公开用户信息
+type Collaborator struct {
+	ID        uint   `json:"id"`
+	Username  string `json:"username"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+// PermissionResponse 协作者及其角色
+type PermissionResponse struct {
+	models.Permission
+	User Collaborator `json:"user"`
+	// Notice 授予的角色需要说明的地方，只在共享时返回
+	Notice string `json:"notice,omitempty"`
+}
+
+// commenterNotice 评论功能尚未实现，commenter 的权限目前与 viewer 相同
+const commenterNotice = "commenter 目前与 viewer 权限相同，只能查看"
+
+// ReceivedPermission 共享给当前用户的笔记或分类
+type ReceivedPermission struct {
+	models.Permission
+	Owner Collaborator `json:"owner"`
+	// Title 笔记标题或分类名称
+	Title string `json:"title"`
+}
+
+// GetNotePermissions 获取笔记的协作者列表
+func (h *PermissionHandler) GetNotePermissions(ctx iris.Context) {
+	h.listPermissions(ctx, "note_id", h.ownedNote)
+}
+
+// ShareNote 邀请用户协作笔记，用户已是协作者时修改其角色
+func (h *PermissionHandler) ShareNote(ctx iris.Context) {
+	h.grant(ctx, "note_id", h.ownedNote)
+}
+
+// GetCategoryPermissions 获取分类的协作者列表
+func (h *PermissionHandler) GetCategoryPermissions(ctx iris.Context) {
+	h.listPermissions(ctx, "category_id", h.ownedCategory)
+}
+
+// ShareCategory 邀请用户协作分类，权限作用于分类及其子孙分类中的所有笔记
+func (h *PermissionHandler) ShareCategory(ctx iris.Context) {
+	h.grant(ctx, "category_id", h.ownedCategory)
+}
+
+// ownedNote 检查路由参数 id 是否是当前用户未删除的笔记
+func (h *PermissionHandler) ownedNote(ctx iris.Context) bool {
+	var count int64
+	if err := h.db.Model(&models.Note{}).Where("id = ? AND user_id = ?", ctx.Params().Get("id"), ctx.Values().Get("userID").(uint)).
+		Count(&count).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取笔记失败"})
+		return false
+	}
+	if count == 0 {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "笔记不存在"})
+		return false
+	}
+	return true
+}
+
+// ownedCategory 检查路由参数 id 是否是当前用户的分类
+func (h *PermissionHandler) ownedCategory(ctx iris.Context) bool {
+	var count int64
+	if err := h.db.Model(&models.Category{}).Where("id = ? AND user_id = ?", ctx.Params().Get("id"), ctx.Values().Get("userID").(uint)).
+		Count(&count).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取分类失败"})
+		return false
+	}
+	if count == 0 {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "分类不存在"})
+		return false
+	}
+	return true
+}
+
+// listPermissions 返回 column（note_id 或 category_id）为路由参数 id 的协作者
+func (h *PermissionHandler) listPermissions(ctx iris.Context, column string, owned func(iris.Context) bool) {
+	if !owned(ctx) {
+		return
+	}
+
+	var permissions []models.Permission
+	if err := h.db.Where(column+" = ?", ctx.Params().Get("id")).Order("created_at").Find(&permissions).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取协作者失败"})
+		return
+	}
+
+	userIDs := make([]uint, 0, len(permissions))
+	for _, permission := range permissions {
+		userIDs = append(userIDs, permission.UserID)
+	}
+	users, err := h.collaborators(userIDs)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取协作者失败"})
+		return
+	}
+
+	response := make([]PermissionResponse, 0, len(permissions))
+	for _, permission := range permissions {
+		response = append(response, PermissionResponse{Permission: permission, User: users[permission.UserID]})
+	}
+	ctx.JSON(response)
+}
+
+// grant 把路由参数 id 对应的笔记或分类共享给请求中的用户
+func (h *PermissionHandler) grant(ctx iris.Context, column string, owned func(iris.Context) bool) {
+	userID := ctx.Values().Get("userID").(uint)
+	var req PermissionRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "无效的请求数据"})
+		return
+	}
+	if !models.ValidRole(req.Role) {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "无效的角色，可选 viewer、commenter、editor"})
+		return
+	}
+	if !owned(ctx) {
+		return
+	}
+
+	var invitee models.User
+	name := strings.TrimSpace(req.User)
+	if err := h.db.Where("username = ? OR LOWER(email) = LOWER(?)", name, name).First(&invitee).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.JSON(iris.Map{"error": "用户不存在"})
+			return
+		}
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "查找用户失败"})
+		return
+	}
+	if invitee.ID == userID {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "不能共享给自己"})
+		return
+	}
+
+	id := ctx.Params().Get("id")
+	var permission models.Permission
+	err := h.db.Where(column+" = ? AND user_id = ?", id, invitee.ID).First(&permission).Error
+	switch {
+	case err == nil:
+		if err := h.db.Model(&permission).Update("role", req.Role).Error; err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "共享失败"})
+			return
+		}
+	case err == gorm.ErrRecordNotFound:
+		permission = models.Permission{
+			ID:      uuid.New().String(),
+			OwnerID: userID,
+			UserID:  invitee.ID,
+			Role:    req.Role,
+		}
+		if column == "note_id" {
+			permission.NoteID = id
+		} else {
+			permission.CategoryID = id
+		}
+		if err := h.db.Create(&permission).Error; err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "共享失败"})
+			return
+		}
+		ctx.StatusCode(iris.StatusCreated)
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "共享失败"})
+		return
+	}
+
+	response := PermissionResponse{
+		Permission: permission,
+		User:       Collaborator{ID: invitee.ID, Username: invitee.Username, AvatarURL: invitee.AvatarURL},
+	}
+	if permission.Role == models.RoleCommenter {
+		response.Notice = commenterNotice
+	}
+	ctx.JSON(response)
+}
+
+// UpdatePermission 修改协作者的角色，只有所有者可以修改
+func (h *PermissionHandler) UpdatePermission(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+	var req UpdatePermissionRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "无效的请求数据"})
+		return
+	}
+	if !models.ValidRole(req.Role) {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "无效的角色，可选 viewer、commenter、editor"})
+		return
+	}
+
+	var permission models.Permission
+	if err := h.db.Where("id = ? AND owner_id = ?", ctx.Params().Get("id"), userID).First(&permission).Error; err != nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "共享不存在"})
+		return
+	}
+
+	if err := h.db.Model(&permission).Update("role", req.Role).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "修改角色失败"})
+		return
+	}
+	h.collab.RecheckUser(permission.UserID)
+
+	ctx.JSON(permission)
+}
+
+// DeletePermission 取消共享，所有者可以移除协作者，协作者也可以退出共享
+func (h *PermissionHandler) DeletePermission(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	var permission models.Permission
+	if err := h.db.Where("id = ? AND (owner_id = ? OR user_id = ?)", ctx.Params().Get("id"), userID, userID).
+		First(&permission).Error; err != nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "共享不存在"})
+		return
+	}
+
+	if err := h.db.Delete(&permission).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "取消共享失败"})
+		return
+	}
+	h.collab.RecheckUser(permission.UserID)
+
+	ctx.JSON(iris.Map{"message": "已取消共享"})
+}
+
+// GetReceivedPermissions 获取共享给当前用户的笔记和分类
+func (h *PermissionHandler) GetReceivedPermissions(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	var rows []struct {
+		models.Permission
+		NoteTitle    string
+		CategoryName string
+	}
+	if err := h.db.Model(&models.Permission{}).
+		Select("permissions.*, notes.title AS note_title, categories.name AS category_name").
+		Joins("LEFT JOIN notes ON notes.id = permissions.note_id AND notes.deleted_at IS NULL").
+		Joins("LEFT JOIN categories ON categories.id = permissions.category_id").
+		Where("permissions.user_id = ? AND (notes.id IS NOT NULL OR categories.id IS NOT NULL)", userID).
+		Order("permissions.created_at desc").
+		Scan(&rows).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取共享失败"})
+		return
+	}
+
+	ownerIDs := make([]uint, 0, len(rows))
+	for _, row := range rows {
+		ownerIDs = append(ownerIDs, row.OwnerID)
+	}
+	owners, err := h.collaborators(ownerIDs)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取共享失败"})
+		return
+	}
+
+	response := make([]ReceivedPermission, 0, len(rows))
+	for _, row := range rows {
+		title := row.NoteTitle
+		if row.CategoryID != "" {
+			title = row.CategoryName
+		}
+		response = append(response, ReceivedPermission{Permission: row.Permission, Owner: owners[row.OwnerID], Title: title})
+	}
+	ctx.JSON(response)
+}
+
+// collaborators 按ID查询用户的公开信息
+func (h *PermissionHandler) collaborators(ids []uint) (map[uint]Collaborator, error) {
+	result := make(map[uint]Collaborator, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	var users []models.User
+	if err := h.db.Select("id", "username", "avatar_url").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		result[user.ID] = Collaborator{ID: user.ID, Username: user.Username, AvatarURL: user.AvatarURL}
+	}
+	return result, nil
+}
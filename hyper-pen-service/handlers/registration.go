@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"hyper-pen-service/models"
+	"hyper-pen-service/utils"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// pendingRegistrationTTL 首次使用第三方帐户登录后完成注册的期限
+const pendingRegistrationTTL = 30 * time.Minute
+
+var (
+	errUsernameTaken = errors.New("Username is already taken")
+	errEmailTaken    = errors.New("Email is already registered")
+	errIdentityTaken = errors.New("This account is already registered")
+)
+
+// CompleteRegistrationRequest 完成注册的请求，password 为空时只能使用第三方帐户登录
+type CompleteRegistrationRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// startRegistration 保存第三方帐户信息并签发只能用于完成注册的受限令牌，返回提示客户端进入注册流程的响应
+func (h *AuthHandler) startRegistration(pending models.PendingRegistration) (map[string]interface{}, error) {
+	now := time.Now()
+	if err := h.db.Where("expires_at <= ?", now).Delete(&models.PendingRegistration{}).Error; err != nil {
+		log.Printf("清理过期的待注册记录失败: %v", err)
+	}
+
+	pending.ID = uuid.New().String()
+	pending.ExpiresAt = now.Add(pendingRegistrationTTL)
+	if err := h.db.Create(&pending).Error; err != nil {
+		return nil, err
+	}
+
+	token, err := utils.GenerateRegistrationToken(&pending)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"registration_required": true,
+		"registration_token":    token,
+		"registration":          pending,
+	}, nil
+}
+
+// CompleteRegistration 使用受限令牌完成第三方帐户的首次注册，创建用户并返回正式令牌
+func (h *AuthHandler) CompleteRegistration(ctx iris.Context) {
+	authHeader := ctx.GetHeader("Authorization")
+	if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(iris.Map{"error": "Registration token is required"})
+		return
+	}
+	claims, err := utils.ParseToken(authHeader[7:])
+	if err != nil || claims.RegistrationID == "" {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(iris.Map{"error": "Invalid registration token"})
+		return
+	}
+
+	var req CompleteRegistrationRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Invalid request"})
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Username == "" || req.Email == "" {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Username and email are required"})
+		return
+	}
+
+	var pending models.PendingRegistration
+	if err := h.db.Where("id = ? AND expires_at > ?", claims.RegistrationID, time.Now()).First(&pending).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			ctx.StatusCode(iris.StatusGone)
+			ctx.JSON(iris.Map{"error": "Registration has expired, please sign in again"})
+			return
+		}
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to find registration"})
+		return
+	}
+
+	user := models.User{
+		Username:  req.Username,
+		Email:     req.Email,
+		AvatarURL: pending.AvatarURL,
+	}
+	if req.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "Failed to hash password"})
+			return
+		}
+		user.Password = string(hashedPassword)
+	}
+	if pending.Provider == models.ProviderGitHub {
+		user.GitHubToken = pending.AccessToken
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Identity{}).Where("provider = ? AND provider_id = ?", pending.Provider, pending.ProviderID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errIdentityTaken
+		}
+		if err := checkUserAvailable(tx, user.Username, user.Email); err != nil {
+			return err
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.Identity{
+			UserID:     user.ID,
+			Provider:   pending.Provider,
+			ProviderID: pending.ProviderID,
+			Name:       pending.Username,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&pending).Error
+	})
+	if err != nil {
+		writeRegisterError(ctx, err)
+		return
+	}
+
+	token, err := utils.GenerateToken(&user)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to generate token"})
+		return
+	}
+
+	ctx.JSON(LoginResponse{
+		Token: token,
+		User:  user,
+	})
+}
+
+// checkUserAvailable 检查用户名和邮箱是否已被其他用户使用
+func checkUserAvailable(tx *gorm.DB, username, email string) error {
+	var count int64
+	if err := tx.Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errUsernameTaken
+	}
+	if err := tx.Model(&models.User{}).Where("email = ?", email).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errEmailTaken
+	}
+	return nil
+}
+
+// writeRegisterError 把注册时的冲突转换为 409，其他错误返回 500
+func writeRegisterError(ctx iris.Context, err error) {
+	switch err {
+	case errUsernameTaken, errEmailTaken, errIdentityTaken:
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(iris.Map{"error": err.Error()})
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to create user"})
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"errors"
+	"hyper-pen-service/models"
+	"hyper-pen-service/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// GetRevisions 获取笔记的修订版本列表（不含正文）
+func (h *NoteHandler) GetRevisions(ctx iris.Context) {
+	note, ok := h.findAccessibleNote(ctx, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	var revisions []models.NoteRevision
+	if err := h.db.Select("id", "note_id", "number", "version", "user_id", "editor_id", "title", "category_id", "created_at").
+		Where("note_id = ?", note.ID).Order("number desc").Find(&revisions).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch revisions"})
+		return
+	}
+
+	ctx.JSON(revisions)
+}
+
+// GetRevision 获取单个修订版本
+func (h *NoteHandler) GetRevision(ctx iris.Context) {
+	note, ok := h.findAccessibleNote(ctx, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	revision, ok := h.findRevision(ctx, note.ID, ctx.Params().GetIntDefault("number", 0))
+	if !ok {
+		return
+	}
+
+	ctx.JSON(revision)
+}
+
+// DiffRevisions 比较两个修订版本，mode=line（默认）按行比较，mode=word 按词比较
+func (h *NoteHandler) DiffRevisions(ctx iris.Context) {
+	note, ok := h.findAccessibleNote(ctx, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	from, err := ctx.URLParamInt("from")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Invalid from revision"})
+		return
+	}
+	to, err := ctx.URLParamInt("to")
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Invalid to revision"})
+		return
+	}
+
+	mode := ctx.URLParamDefault("mode", "line")
+	if mode != "line" && mode != "word" {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "Invalid diff mode"})
+		return
+	}
+
+	fromRevision, ok := h.findRevision(ctx, note.ID, from)
+	if !ok {
+		return
+	}
+	toRevision, ok := h.findRevision(ctx, note.ID, to)
+	if !ok {
+		return
+	}
+
+	diff := utils.DiffLines
+	if mode == "word" {
+		diff = utils.DiffWords
+	}
+
+	ctx.JSON(iris.Map{
+		"from":    from,
+		"to":      to,
+		"mode":    mode,
+		"title":   utils.DiffWords(fromRevision.Title, toRevision.Title),
+		"content": diff(fromRevision.Content, toRevision.Content),
+	})
+}
+
+// RestoreRevision 将笔记恢复为指定修订版本的内容，并记录为新的修订版本，需要编辑权限
+func (h *NoteHandler) RestoreRevision(ctx iris.Context) {
+	note, ok := h.findAccessibleNote(ctx, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	revision, ok := h.findRevision(ctx, note.ID, ctx.Params().GetIntDefault("number", 0))
+	if !ok {
+		return
+	}
+
+	tagIDs := []string{}
+	if err := h.db.Table("note_tags").Where("note_id = ?", note.ID).Pluck("tag_id", &tagIDs).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to restore revision"})
+		return
+	}
+
+	restored, relinked, err := h.updateNote(ctx.Values().Get("userID").(uint), note.ID, NoteRequest{
+		Title:      revision.Title,
+		Content:    revision.Content,
+		CategoryID: note.CategoryID,
+		TagIDs:     tagIDs,
+		Version:    note.Version,
+	})
+	if err != nil {
+		var conflict *versionConflictError
+		if errors.As(err, &conflict) {
+			ctx.StatusCode(iris.StatusConflict)
+			ctx.JSON(iris.Map{"error": "Note has been modified by someone else"})
+			return
+		}
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to restore revision"})
+		return
+	}
+
+	if relinked == nil {
+		relinked = []RelinkedNote{}
+	}
+
+	ctx.Header("ETag", noteETag(restored))
+	ctx.JSON(iris.Map{
+		"message":        "Revision restored successfully",
+		"note":           restored,
+		"relinked_notes": relinked,
+	})
+}
+
+// findAccessibleNote 查找路由参数 id 对应的笔记并检查当前用户至少具有 minRole 权限，失败时写入错误响应
+func (h *NoteHandler) findAccessibleNote(ctx iris.Context, minRole string) (*models.Note, bool) {
+	id := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	note, err := accessibleNote(h.db, userID, id, minRole)
+	if err != nil {
+		writeNoteAccessError(ctx, err)
+		return nil, false
+	}
+	return note, true
+}
+
+// findRevision 查找笔记的指定修订版本，失败时写入错误响应
+func (h *NoteHandler) findRevision(ctx iris.Context, noteID string, number int) (*models.NoteRevision, bool) {
+	var revision models.NoteRevision
+	if err := h.db.Where("note_id = ? AND number = ?", noteID, number).First(&revision).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.JSON(iris.Map{"error": "Revision not found"})
+			return nil, false
+		}
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch revision"})
+		return nil, false
+	}
+	return &revision, true
+}
+
+// ensureRevisionBaseline 为启用修订历史之前创建的笔记补记当前内容，避免第一次更新丢失原文
+func ensureRevisionBaseline(tx *gorm.DB, note *models.Note) error {
+	var count int64
+	if err := tx.Model(&models.NoteRevision{}).Where("note_id = ?", note.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return recordRevision(tx, note, note.UserID)
+}
+
+// recordRevision 记录 editorID 保存的笔记当前内容为新的修订版本，并按所有者的保留策略清理旧版本
+func recordRevision(tx *gorm.DB, note *models.Note, editorID uint) error {
+	var last models.NoteRevision
+	number := 1
+	err := tx.Select("number").Where("note_id = ?", note.ID).Order("number desc").First(&last).Error
+	if err == nil {
+		number = last.Number + 1
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	revision := models.NoteRevision{
+		ID:         uuid.New().String(),
+		NoteID:     note.ID,
+		Number:     number,
+		Version:    note.Version,
+		UserID:     note.UserID,
+		EditorID:   editorID,
+		Title:      note.Title,
+		Content:    note.Content,
+		CategoryID: note.CategoryID,
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		return err
+	}
+
+	return pruneRevisions(tx, note.UserID, note.ID)
+}
+
+// latestRevisionNumber 同一笔记最新修订版本号的子查询
+const latestRevisionNumber = "(SELECT MAX(r.number) FROM note_revisions r WHERE r.note_id = note_revisions.note_id)"
+
+// pruneRevisions 按用户的保留条数和保留天数删除旧的修订版本，每篇笔记的最新版本始终保留；noteID 为空时清理该用户的全部笔记
+func pruneRevisions(tx *gorm.DB, userID uint, noteID string) error {
+	var user models.User
+	if err := tx.Select("revision_keep_count", "revision_keep_days").First(&user, userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+
+	scope := func() *gorm.DB {
+		db := tx.Where("user_id = ?", userID)
+		if noteID != "" {
+			db = db.Where("note_id = ?", noteID)
+		}
+		return db
+	}
+
+	if user.RevisionKeepCount > 0 {
+		if err := scope().Where("number <= "+latestRevisionNumber+" - ?", user.RevisionKeepCount).
+			Delete(&models.NoteRevision{}).Error; err != nil {
+			return err
+		}
+	}
+
+	if user.RevisionKeepDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -user.RevisionKeepDays)
+		if err := scope().Where("created_at < ? AND number < "+latestRevisionNumber, cutoff).
+			Delete(&models.NoteRevision{}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
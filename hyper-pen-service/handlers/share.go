@@ -1,40 +1,148 @@
 package handlers
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hyper-pen-service/config"
 	"hyper-pen-service/models"
+	"hyper-pen-service/render"
+	"log"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/kataras/iris/v12"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+// sharePasswordHeader 访问受密码保护的分享链接时携带密码的请求头
+const sharePasswordHeader = "X-Share-Password"
+
+// 访问记录每次最多返回的条数
+const (
+	defaultAccessLogLimit = 100
+	maxAccessLogLimit     = 1000
+)
+
 // ShareHandler 处理共享笔记相关的请求
 type ShareHandler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	renderer *render.Renderer
 }
 
 // NewShareHandler 创建新的共享处理器
-func NewShareHandler(db *gorm.DB) *ShareHandler {
-	return &ShareHandler{db: db}
+func NewShareHandler(db *gorm.DB, renderer *render.Renderer) *ShareHandler {
+	return &ShareHandler{db: db, renderer: renderer}
+}
+
+// ShareLinkResponse 分享链接信息，不包含密码哈希
+type ShareLinkResponse struct {
+	models.ShareLink
+	HasPassword bool `json:"has_password"`
+}
+
+func newShareLinkResponse(link models.ShareLink) ShareLinkResponse {
+	return ShareLinkResponse{ShareLink: link, HasPassword: link.PasswordHash != ""}
 }
 
-// GetSharedNote 获取共享的笔记
+// SharePasswordRequest 通过 POST 提交分享密码的请求
+type SharePasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// GetSharedNote 获取共享的笔记。受密码保护的链接需要通过 X-Share-Password 请求头提供密码，
+// 也可以使用 POST 在请求体中提交；每次成功访问计入访问次数并记录访问日志。
+// format=html 时同时返回渲染并清理后的 HTML
 func (h *ShareHandler) GetSharedNote(ctx iris.Context) {
-	token := ctx.Params().Get("token")
+	shareLink, ok := h.openShareLink(ctx)
+	if !ok {
+		return
+	}
+	if shareLink.CategoryID != "" {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{
+			"error":       "该链接分享的是分类，请通过笔记列表访问",
+			"category_id": shareLink.CategoryID,
+		})
+		return
+	}
 
-	var shareLink models.ShareLink
-	if err := h.db.Where("token = ? AND expires_at > ?", token, time.Now()).First(&shareLink).Error; err != nil {
+	var note models.Note
+	if err := h.db.Preload("Category").Preload("Tags").Where("id = ?", shareLink.NoteID).First(&note).Error; err != nil {
 		ctx.StatusCode(iris.StatusNotFound)
 		ctx.JSON(iris.Map{
-			"error": "分享链接不存在或已过期",
+			"error": "笔记不存在",
 		})
 		return
 	}
 
+	h.serveSharedNote(ctx, shareLink, &note)
+}
+
+// GetSharedCategory 获取共享分类（包括子孙分类）中的笔记列表，不包含正文。
+// 列表访问不计入访问次数，但访问次数用完后同样不可访问
+func (h *ShareHandler) GetSharedCategory(ctx iris.Context) {
+	shareLink, ok := h.openShareLink(ctx)
+	if !ok {
+		return
+	}
+	if shareLink.CategoryID == "" {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "该链接分享的不是分类"})
+		return
+	}
+	if shareLink.MaxViews > 0 && shareLink.ViewCount >= shareLink.MaxViews {
+		h.logAccess(ctx, shareLink, "", models.ShareAccessLimitReached)
+		ctx.StatusCode(iris.StatusGone)
+		ctx.JSON(iris.Map{"error": "分享链接的访问次数已用完"})
+		return
+	}
+
+	var category models.Category
+	if err := h.db.Where("id = ?", shareLink.CategoryID).First(&category).Error; err != nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "分类不存在"})
+		return
+	}
+
+	var notes []models.Note
+	if err := sharedCategoryNotes(h.db, shareLink.CategoryID).
+		Select("id", "user_id", "category_id", "title", "version", "created_at", "updated_at", "has_broken_links").
+		Preload("Category").Preload("Tags").
+		Order("updated_at desc").Find(&notes).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取笔记列表失败"})
+		return
+	}
+	h.logAccess(ctx, shareLink, "", models.ShareAccessListed)
+
+	ctx.JSON(iris.Map{
+		"category":   iris.Map{"id": category.ID, "name": category.Name},
+		"notes":      notes,
+		"expires_at": shareLink.ExpiresAt,
+	})
+}
+
+// GetSharedCategoryNote 获取共享分类中的一篇笔记，包括创建链接后才加入分类的笔记
+func (h *ShareHandler) GetSharedCategoryNote(ctx iris.Context) {
+	shareLink, ok := h.openShareLink(ctx)
+	if !ok {
+		return
+	}
+	if shareLink.CategoryID == "" {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "该链接分享的不是分类"})
+		return
+	}
+
 	var note models.Note
-	if err := h.db.Preload("Category").Preload("Tags").First(&note, shareLink.NoteID).Error; err != nil {
+	if err := sharedCategoryNotes(h.db, shareLink.CategoryID).Preload("Category").Preload("Tags").
+		Where("notes.id = ?", ctx.Params().Get("noteId")).First(&note).Error; err != nil {
 		ctx.StatusCode(iris.StatusNotFound)
 		ctx.JSON(iris.Map{
 			"error": "笔记不存在",
@@ -42,12 +150,129 @@ func (h *ShareHandler) GetSharedNote(ctx iris.Context) {
 		return
 	}
 
+	h.serveSharedNote(ctx, shareLink, &note)
+}
+
+// openShareLink 查找路由参数 token 对应的有效分享链接并校验密码，失败时写入错误响应
+func (h *ShareHandler) openShareLink(ctx iris.Context) (*models.ShareLink, bool) {
+	password := ctx.GetHeader(sharePasswordHeader)
+	if ctx.Method() == iris.MethodPost {
+		var req SharePasswordRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(iris.Map{"error": "无效的请求参数"})
+			return nil, false
+		}
+		password = req.Password
+	}
+
+	var shareLink models.ShareLink
+	if err := h.db.Where("token = ? AND expires_at > ?", ctx.Params().Get("token"), time.Now()).First(&shareLink).Error; err != nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{
+			"error": "分享链接不存在或已过期",
+		})
+		return nil, false
+	}
+
+	if shareLink.PasswordHash != "" {
+		if password == "" {
+			h.logAccess(ctx, &shareLink, "", models.ShareAccessPasswordRequired)
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.JSON(iris.Map{"error": "该分享需要密码", "password_required": true})
+			return nil, false
+		}
+		if bcrypt.CompareHashAndPassword([]byte(shareLink.PasswordHash), []byte(password)) != nil {
+			h.logAccess(ctx, &shareLink, "", models.ShareAccessWrongPassword)
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.JSON(iris.Map{"error": "密码错误", "password_required": true})
+			return nil, false
+		}
+	}
+	return &shareLink, true
+}
+
+// serveSharedNote 计入一次访问并返回笔记，访问次数用完时返回 410
+func (h *ShareHandler) serveSharedNote(ctx iris.Context, shareLink *models.ShareLink, note *models.Note) {
+	format, err := noteFormat(ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "无效的格式，只支持 markdown 或 html"})
+		return
+	}
+
+	// 在同一条语句中检查并增加访问次数，并发访问也不会超过上限
+	result := h.db.Model(&models.ShareLink{}).
+		Where("id = ? AND (max_views = 0 OR view_count < max_views)", shareLink.ID).
+		UpdateColumn("view_count", gorm.Expr("view_count + 1"))
+	if result.Error != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取分享失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		h.logAccess(ctx, shareLink, note.ID, models.ShareAccessLimitReached)
+		ctx.StatusCode(iris.StatusGone)
+		ctx.JSON(iris.Map{"error": "分享链接的访问次数已用完"})
+		return
+	}
+	h.logAccess(ctx, shareLink, note.ID, models.ShareAccessViewed)
+
+	if format == formatHTML {
+		html, err := h.renderer.Render(note.ID, note.Version, note.Content)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "渲染笔记失败"})
+			return
+		}
+		// 缓存的是不带 token 的结果，附件地址在每次返回前替换
+		note.HTML = shareAttachmentURLs(html, note.ID, shareLink.Token)
+	}
+	note.Content = shareAttachmentURLs(note.Content, note.ID, shareLink.Token)
 	ctx.JSON(note)
 }
 
+// sharedCategoryNotes 限定为分类及其子孙分类中属于分类所有者的笔记
+func sharedCategoryNotes(db *gorm.DB, categoryID string) *gorm.DB {
+	return db.Model(&models.Note{}).
+		Where("notes.category_id IN ("+fmt.Sprintf(categorySubtreeSQL, "id = ?")+")", categoryID).
+		Where("notes.user_id = (SELECT user_id FROM categories WHERE id = ?)", categoryID)
+}
+
+// logAccess 记录一次分享链接访问，noteID 为访问的笔记；记录失败不影响访问
+func (h *ShareHandler) logAccess(ctx iris.Context, shareLink *models.ShareLink, noteID, result string) {
+	entry := models.ShareAccessLog{
+		ShareLinkID: shareLink.ID,
+		NoteID:      noteID,
+		IPHash:      hashIP(ctx.RemoteAddr()),
+		UserAgent:   ctx.GetHeader("User-Agent"),
+		Result:      result,
+		AccessedAt:  time.Now(),
+	}
+	if err := h.db.Create(&entry).Error; err != nil {
+		log.Printf("记录分享访问失败 %s: %v", shareLink.ID, err)
+	}
+}
+
+// hashIP 使用 JWT 密钥对 IP 做 HMAC，可以区分访问者但无法还原 IP
+func hashIP(ip string) string {
+	mac := hmac.New(sha256.New, []byte(config.AppConfig.JWTSecret))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 // CreateShareLinkRequest 创建分享链接的请求
 type CreateShareLinkRequest struct {
-	ExpiresIn int `json:"expires_in"` // 过期时间（小时），0表示永久
+	ExpiresIn int    `json:"expires_in"` // 过期时间（小时），0表示永久
+	Password  string `json:"password"`   // 访问密码，为空表示不需要密码
+	MaxViews  int    `json:"max_views"`  // 最多访问次数，0表示不限制
+}
+
+// UpdateShareLinkRequest 修改分享链接的请求，为 nil 的字段保持不变
+type UpdateShareLinkRequest struct {
+	ExpiresIn *int    `json:"expires_in"` // 从现在起的过期时间（小时），0表示永久
+	Password  *string `json:"password"`   // 为空字符串时取消密码
+	MaxViews  *int    `json:"max_views"`
 }
 
 // CreateShareLink 创建分享链接
@@ -55,6 +280,24 @@ func (h *ShareHandler) CreateShareLink(ctx iris.Context) {
 	noteID := ctx.Params().Get("id")
 	userID := ctx.Values().Get("userID").(uint)
 
+	h.createShareLink(ctx, models.ShareLink{NoteID: noteID}, func() bool {
+		// 检查笔记是否存在且属于当前用户
+		return h.checkNoteOwner(ctx, userID, noteID)
+	})
+}
+
+// CreateCategoryShareLink 创建分类的分享链接，链接可以访问分类及其子孙分类中的所有笔记
+func (h *ShareHandler) CreateCategoryShareLink(ctx iris.Context) {
+	categoryID := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	h.createShareLink(ctx, models.ShareLink{CategoryID: categoryID}, func() bool {
+		return h.checkCategoryOwner(ctx, userID, categoryID)
+	})
+}
+
+// createShareLink 解析请求并保存分享链接，checkOwner 失败时已写入错误响应
+func (h *ShareHandler) createShareLink(ctx iris.Context, shareLink models.ShareLink, checkOwner func() bool) {
 	// 解析请求
 	var req CreateShareLinkRequest
 	if err := ctx.ReadJSON(&req); err != nil {
@@ -64,39 +307,29 @@ func (h *ShareHandler) CreateShareLink(ctx iris.Context) {
 		})
 		return
 	}
-
-	// 检查笔记是否存在且属于当前用户
-	var note models.Note
-	if err := h.db.First(&note, noteID).Error; err != nil {
-		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(iris.Map{
-			"error": "笔记不存在",
-		})
+	if req.ExpiresIn < 0 || req.MaxViews < 0 {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "过期时间和访问次数不能为负数"})
 		return
 	}
 
-	if note.UserID != userID {
-		ctx.StatusCode(iris.StatusForbidden)
-		ctx.JSON(iris.Map{
-			"error": "无权操作此笔记",
-		})
+	if !checkOwner() {
 		return
 	}
 
-	// 计算过期时间
-	var expiresAt time.Time
-	if req.ExpiresIn > 0 {
-		expiresAt = time.Now().Add(time.Duration(req.ExpiresIn) * time.Hour)
-	} else {
-		// 设置为100年后过期，相当于永久
-		expiresAt = time.Now().Add(100 * 365 * 24 * time.Hour)
-	}
-
 	// 生成分享链接
-	shareLink := models.ShareLink{
-		NoteID:    noteID,
-		Token:     generateToken(),
-		ExpiresAt: expiresAt,
+	shareLink.ID = uuid.New().String()
+	shareLink.Token = generateToken()
+	shareLink.MaxViews = req.MaxViews
+	shareLink.ExpiresAt = shareExpiry(req.ExpiresIn)
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(iris.Map{"error": "无效的密码"})
+			return
+		}
+		shareLink.PasswordHash = string(hash)
 	}
 
 	if err := h.db.Create(&shareLink).Error; err != nil {
@@ -107,34 +340,83 @@ func (h *ShareHandler) CreateShareLink(ctx iris.Context) {
 		return
 	}
 
-	ctx.JSON(shareLink)
+	ctx.JSON(newShareLinkResponse(shareLink))
 }
 
-// GetShareLinks 获取笔记的所有分享链接
-func (h *ShareHandler) GetShareLinks(ctx iris.Context) {
-	noteID := ctx.Params().GetUintDefault("id", 0)
-	userID := ctx.Values().Get("userID").(uint)
+// shareExpiry 计算过期时间，hours 为 0 时设置为100年后过期，相当于永久
+func shareExpiry(hours int) time.Time {
+	if hours > 0 {
+		return time.Now().Add(time.Duration(hours) * time.Hour)
+	}
+	return time.Now().Add(100 * 365 * 24 * time.Hour)
+}
 
-	// 检查笔记是否存在且属于当前用户
-	var note models.Note
-	if err := h.db.First(&note, noteID).Error; err != nil {
+// checkNoteOwner 检查笔记是否存在且属于当前用户，失败时写入错误响应。协作者不能管理分享链接
+func (h *ShareHandler) checkNoteOwner(ctx iris.Context, userID uint, noteID string) bool {
+	_, err := accessibleNote(h.db.Select("id", "user_id", "category_id"), userID, noteID, models.RoleOwner)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, gorm.ErrRecordNotFound):
 		ctx.StatusCode(iris.StatusNotFound)
 		ctx.JSON(iris.Map{
 			"error": "笔记不存在",
 		})
-		return
-	}
-
-	if note.UserID != userID {
+	case errors.Is(err, errNoteForbidden):
 		ctx.StatusCode(iris.StatusForbidden)
 		ctx.JSON(iris.Map{
 			"error": "无权操作此笔记",
 		})
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取笔记失败"})
+	}
+	return false
+}
+
+// checkCategoryOwner 检查分类是否存在且属于当前用户，失败时写入错误响应
+func (h *ShareHandler) checkCategoryOwner(ctx iris.Context, userID uint, categoryID string) bool {
+	var count int64
+	if err := h.db.Model(&models.Category{}).Where("id = ? AND user_id = ?", categoryID, userID).Count(&count).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取分类失败"})
+		return false
+	}
+	if count == 0 {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "分类不存在"})
+		return false
+	}
+	return true
+}
+
+// GetShareLinks 获取笔记的所有分享链接
+func (h *ShareHandler) GetShareLinks(ctx iris.Context) {
+	noteID := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	// 检查笔记是否存在且属于当前用户
+	if !h.checkNoteOwner(ctx, userID, noteID) {
 		return
 	}
+	h.listShareLinks(ctx, "note_id", noteID)
+}
 
+// GetCategoryShareLinks 获取分类的所有分享链接
+func (h *ShareHandler) GetCategoryShareLinks(ctx iris.Context) {
+	categoryID := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	if !h.checkCategoryOwner(ctx, userID, categoryID) {
+		return
+	}
+	h.listShareLinks(ctx, "category_id", categoryID)
+}
+
+// listShareLinks 返回 column 等于 id 的分享链接
+func (h *ShareHandler) listShareLinks(ctx iris.Context, column, id string) {
 	var shareLinks []models.ShareLink
-	if err := h.db.Where("note_id = ?", noteID).Find(&shareLinks).Error; err != nil {
+	if err := h.db.Where(column+" = ?", id).Order("created_at desc").Find(&shareLinks).Error; err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(iris.Map{
 			"error": "获取分享链接失败",
@@ -142,42 +424,179 @@ func (h *ShareHandler) GetShareLinks(ctx iris.Context) {
 		return
 	}
 
-	ctx.JSON(shareLinks)
+	response := make([]ShareLinkResponse, 0, len(shareLinks))
+	for _, link := range shareLinks {
+		response = append(response, newShareLinkResponse(link))
+	}
+	ctx.JSON(response)
 }
 
-// DeleteShareLink 删除分享链接
-func (h *ShareHandler) DeleteShareLink(ctx iris.Context) {
-	shareLinkID := ctx.Params().GetUintDefault("id", 0)
+// RevokeShareLinks 撤销笔记的所有分享链接
+func (h *ShareHandler) RevokeShareLinks(ctx iris.Context) {
+	noteID := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	if !h.checkNoteOwner(ctx, userID, noteID) {
+		return
+	}
+	h.revokeShareLinks(ctx, "note_id", noteID)
+}
+
+// RevokeCategoryShareLinks 撤销分类的所有分享链接
+func (h *ShareHandler) RevokeCategoryShareLinks(ctx iris.Context) {
+	categoryID := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	if !h.checkCategoryOwner(ctx, userID, categoryID) {
+		return
+	}
+	h.revokeShareLinks(ctx, "category_id", categoryID)
+}
+
+// revokeShareLinks 删除 column 等于 id 的分享链接及其访问记录
+func (h *ShareHandler) revokeShareLinks(ctx iris.Context, column, id string) {
+	var revoked int64
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		result, err := deleteShareLinks(tx, column, id)
+		revoked = result
+		return err
+	}); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "撤销分享链接失败"})
+		return
+	}
+
+	ctx.JSON(iris.Map{"message": "分享链接已全部撤销", "revoked": revoked})
+}
+
+// deleteShareLinks 删除 column 等于 id 的分享链接及其访问记录，返回删除的链接数量
+func deleteShareLinks(tx *gorm.DB, column, id string) (int64, error) {
+	if err := tx.Where("share_link_id IN (?)", tx.Model(&models.ShareLink{}).Select("id").Where(column+" = ?", id)).
+		Delete(&models.ShareAccessLog{}).Error; err != nil {
+		return 0, err
+	}
+	result := tx.Where(column+" = ?", id).Delete(&models.ShareLink{})
+	return result.RowsAffected, result.Error
+}
+
+// findOwnedShareLink 查找路由参数 id 对应的、属于当前用户笔记或分类的分享链接，失败时写入错误响应
+func (h *ShareHandler) findOwnedShareLink(ctx iris.Context) (*models.ShareLink, bool) {
+	shareLinkID := ctx.Params().Get("id")
 	userID := ctx.Values().Get("userID").(uint)
 
-	// 检查分享链接是否存在且属于当前用户
 	var shareLink models.ShareLink
-	if err := h.db.First(&shareLink, shareLinkID).Error; err != nil {
-		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(iris.Map{
-			"error": "分享链接不存在",
-		})
+	if err := h.db.Joins("LEFT JOIN notes ON notes.id = share_links.note_id").
+		Joins("LEFT JOIN categories ON categories.id = share_links.category_id").
+		Where("share_links.id = ? AND (notes.user_id = ? OR categories.user_id = ?)", shareLinkID, userID, userID).
+		First(&shareLink).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.JSON(iris.Map{
+				"error": "分享链接不存在",
+			})
+			return nil, false
+		}
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取分享链接失败"})
+		return nil, false
+	}
+	return &shareLink, true
+}
+
+// UpdateShareLink 修改分享链接的过期时间、密码或访问次数上限
+func (h *ShareHandler) UpdateShareLink(ctx iris.Context) {
+	shareLink, ok := h.findOwnedShareLink(ctx)
+	if !ok {
 		return
 	}
 
-	var note models.Note
-	if err := h.db.First(&note, shareLink.NoteID).Error; err != nil {
-		ctx.StatusCode(iris.StatusNotFound)
-		ctx.JSON(iris.Map{
-			"error": "笔记不存在",
-		})
+	var req UpdateShareLinkRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "无效的请求参数"})
 		return
 	}
 
-	if note.UserID != userID {
-		ctx.StatusCode(iris.StatusForbidden)
-		ctx.JSON(iris.Map{
-			"error": "无权操作此分享链接",
-		})
+	updates := map[string]interface{}{}
+	if req.ExpiresIn != nil {
+		if *req.ExpiresIn < 0 {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(iris.Map{"error": "过期时间不能为负数"})
+			return
+		}
+		updates["expires_at"] = shareExpiry(*req.ExpiresIn)
+	}
+	if req.MaxViews != nil {
+		if *req.MaxViews < 0 {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.JSON(iris.Map{"error": "访问次数不能为负数"})
+			return
+		}
+		updates["max_views"] = *req.MaxViews
+	}
+	if req.Password != nil {
+		hash := ""
+		if *req.Password != "" {
+			hashed, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.JSON(iris.Map{"error": "无效的密码"})
+				return
+			}
+			hash = string(hashed)
+		}
+		updates["password_hash"] = hash
+	}
+
+	if len(updates) > 0 {
+		if err := h.db.Model(shareLink).Updates(updates).Error; err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "修改分享链接失败"})
+			return
+		}
+	}
+
+	ctx.JSON(newShareLinkResponse(*shareLink))
+}
+
+// GetShareAccessLog 获取分享链接的访问记录，按时间倒序，limit 默认 100
+func (h *ShareHandler) GetShareAccessLog(ctx iris.Context) {
+	shareLink, ok := h.findOwnedShareLink(ctx)
+	if !ok {
 		return
 	}
 
-	if err := h.db.Delete(&shareLink).Error; err != nil {
+	limit := ctx.URLParamIntDefault("limit", defaultAccessLogLimit)
+	if limit <= 0 {
+		limit = defaultAccessLogLimit
+	} else if limit > maxAccessLogLimit {
+		limit = maxAccessLogLimit
+	}
+
+	var logs []models.ShareAccessLog
+	if err := h.db.Where("share_link_id = ?", shareLink.ID).Order("accessed_at desc").Order("id desc").
+		Limit(limit).Find(&logs).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取访问记录失败"})
+		return
+	}
+
+	ctx.JSON(logs)
+}
+
+// DeleteShareLink 删除分享链接
+func (h *ShareHandler) DeleteShareLink(ctx iris.Context) {
+	shareLink, ok := h.findOwnedShareLink(ctx)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("share_link_id = ?", shareLink.ID).Delete(&models.ShareAccessLog{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(shareLink).Error
+	}); err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(iris.Map{
 			"error": "删除分享链接失败",
@@ -188,6 +607,22 @@ func (h *ShareHandler) DeleteShareLink(ctx iris.Context) {
 	ctx.StatusCode(iris.StatusNoContent)
 }
 
+// BackfillShareLinkIDs 为早期版本创建的没有ID的分享链接逐条生成ID，可以重复执行
+func BackfillShareLinkIDs(db *gorm.DB) error {
+	var rowIDs []int64
+	if err := db.Raw("SELECT rowid FROM share_links WHERE id = '' OR id IS NULL").Scan(&rowIDs).Error; err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, rowID := range rowIDs {
+			if err := tx.Exec("UPDATE share_links SET id = ? WHERE rowid = ?", uuid.New().String(), rowID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // generateToken 生成随机的分享链接token
 func generateToken() string {
 	token := make([]byte, 32)
@@ -1,13 +1,36 @@
 package handlers
 
 import (
+	"errors"
 	"hyper-pen-service/models"
+	"regexp"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/kataras/iris/v12"
 	"gorm.io/gorm"
 )
 
+// defaultTagColor 未指定颜色时（包括导入时新建的标签）使用的颜色，与前端默认值一致
+const defaultTagColor = "#409EFF"
+
+// tagNoteCountSQL 统计使用标签的未删除笔记数量
+const tagNoteCountSQL = `(SELECT COUNT(*) FROM note_tags JOIN notes ON notes.id = note_tags.note_id
+	WHERE note_tags.tag_id = tags.id AND notes.deleted_at IS NULL) AS note_count`
+
+// tagSubtreeCondition 匹配名称为 ? 的标签及其所有子标签，三个参数都是标签名称
+const tagSubtreeCondition = "(LOWER(tags.name) = LOWER(?) OR LOWER(SUBSTR(tags.name, 1, LENGTH(?) + 1)) = LOWER(?) || '" + models.TagSeparator + "')"
+
+var hexColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+var (
+	errTagNameTaken    = errors.New("标签名称已存在，可以使用合并功能")
+	errTagNameRequired = errors.New("标签名称不能为空")
+	errTagColorInvalid = errors.New("标签颜色必须是 #RGB 或 #RRGGBB 格式")
+	errTagIntoSubtree  = errors.New("不能把标签重命名为自己的子标签")
+)
+
 // TagHandler 处理标签相关的请求
 type TagHandler struct {
 	db *gorm.DB
@@ -18,7 +41,23 @@ func NewTagHandler(db *gorm.DB) *TagHandler {
 	return &TagHandler{db: db}
 }
 
-// GetTags 获取用户的所有标签
+// TagRequest 创建或更新标签的请求，更新时为空的字段保持不变
+type TagRequest struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// MergeTagsRequest 合并标签的请求
+type MergeTagsRequest struct {
+	SourceIDs []string `json:"source_ids"`
+}
+
+// withTagNoteCount 查询标签时附带使用该标签的笔记数量
+func withTagNoteCount(db *gorm.DB) *gorm.DB {
+	return db.Select("tags.*, " + tagNoteCountSQL)
+}
+
+// GetTags 获取用户的所有标签及每个标签的笔记数量，tree=true 时按层级返回顶级标签及其 children
 func (h *TagHandler) GetTags(ctx iris.Context) {
 	userID := ctx.Values().Get("userID").(uint)
 	if userID == 0 {
@@ -28,16 +67,57 @@ func (h *TagHandler) GetTags(ctx iris.Context) {
 	}
 
 	var tags []models.Tag
-	if err := h.db.Where("user_id = ?", userID).Find(&tags).Error; err != nil {
+	if err := withTagNoteCount(h.db).Where("user_id = ?", userID).Order("name").Find(&tags).Error; err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(iris.Map{"error": "获取标签失败"})
 		return
 	}
 
+	if ctx.URLParamBoolDefault("tree", false) {
+		ctx.JSON(buildTagTree(tags))
+		return
+	}
 	ctx.JSON(tags)
 }
 
-// CreateTag 创建新标签
+// buildTagTree 按名称层级把标签组织成树，标签挂在最近的已存在的上级标签下，没有上级时视为顶级标签
+func buildTagTree(tags []models.Tag) []*models.Tag {
+	nodes := make(map[string]*models.Tag, len(tags))
+	for i := range tags {
+		nodes[strings.ToLower(tags[i].Name)] = &tags[i]
+	}
+
+	roots := []*models.Tag{}
+	for i := range tags {
+		node := &tags[i]
+		var parent *models.Tag
+		for name := node.ParentName(); name != "" && parent == nil; name = models.ParentTagName(name) {
+			parent = nodes[strings.ToLower(name)]
+		}
+		if parent != nil {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// tagSubtree 返回标签及其所有子标签的ID
+func tagSubtree(tx *gorm.DB, userID uint, id string) ([]string, error) {
+	var tag models.Tag
+	if err := tx.Select("name").Where("id = ? AND user_id = ?", id, userID).First(&tag).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return []string{id}, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	err := tx.Model(&models.Tag{}).Where("user_id = ? AND "+tagSubtreeCondition, userID, tag.Name, tag.Name, tag.Name).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// CreateTag 创建新标签，同一用户的标签名称不区分大小写唯一
 func (h *TagHandler) CreateTag(ctx iris.Context) {
 	userID := ctx.Values().Get("userID").(uint)
 	if userID == 0 {
@@ -46,15 +126,26 @@ func (h *TagHandler) CreateTag(ctx iris.Context) {
 		return
 	}
 
-	var tag models.Tag
-	if err := ctx.ReadJSON(&tag); err != nil {
+	var req TagRequest
+	if err := ctx.ReadJSON(&req); err != nil {
 		ctx.StatusCode(iris.StatusBadRequest)
 		ctx.JSON(iris.Map{"error": "无效的请求数据"})
 		return
 	}
 
-	tag.ID = uuid.New().String()
-	tag.UserID = userID
+	tag := models.Tag{
+		ID:     uuid.New().String(),
+		Name:   models.NormalizeTagName(req.Name),
+		Color:  req.Color,
+		UserID: userID,
+	}
+	if tag.Color == "" {
+		tag.Color = defaultTagColor
+	}
+	if err := h.validateTag(&tag); err != nil {
+		writeTagError(ctx, err, "创建标签失败")
+		return
+	}
 
 	if err := h.db.Create(&tag).Error; err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
@@ -66,7 +157,8 @@ func (h *TagHandler) CreateTag(ctx iris.Context) {
 	ctx.JSON(tag)
 }
 
-// UpdateTag 更新标签
+// UpdateTag 更新标签的名称或颜色，重命名不会改变标签ID，笔记上的标签随之更新；
+// 子标签的名称同时改为以新名称开头
 func (h *TagHandler) UpdateTag(ctx iris.Context) {
 	userID := ctx.Values().Get("userID").(uint)
 	if userID == 0 {
@@ -77,7 +169,7 @@ func (h *TagHandler) UpdateTag(ctx iris.Context) {
 
 	tagID := ctx.Params().Get("id")
 	var tag models.Tag
-	if err := h.db.Where("id = ? AND user_id = ?", tagID, userID).First(&tag).Error; err != nil {
+	if err := withTagNoteCount(h.db).Where("id = ? AND user_id = ?", tagID, userID).First(&tag).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			ctx.StatusCode(iris.StatusNotFound)
 			ctx.JSON(iris.Map{"error": "标签不存在"})
@@ -88,22 +180,265 @@ func (h *TagHandler) UpdateTag(ctx iris.Context) {
 		return
 	}
 
-	if err := ctx.ReadJSON(&tag); err != nil {
+	var req TagRequest
+	if err := ctx.ReadJSON(&req); err != nil {
 		ctx.StatusCode(iris.StatusBadRequest)
 		ctx.JSON(iris.Map{"error": "无效的请求数据"})
 		return
 	}
 
-	if err := h.db.Save(&tag).Error; err != nil {
-		ctx.StatusCode(iris.StatusInternalServerError)
-		ctx.JSON(iris.Map{"error": "更新标签失败"})
+	oldName := tag.Name
+	if name := models.NormalizeTagName(req.Name); name != "" {
+		tag.Name = name
+	}
+	if req.Color != "" {
+		tag.Color = req.Color
+	}
+	if err := h.validateTag(&tag); err != nil {
+		writeTagError(ctx, err, "更新标签失败")
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&tag).Update("color", tag.Color).Error; err != nil {
+			return err
+		}
+		if tag.Name == oldName {
+			return nil
+		}
+		return renameTagSubtree(tx, userID, oldName, tag.Name)
+	}); err != nil {
+		writeTagError(ctx, err, "更新标签失败")
 		return
 	}
 
 	ctx.JSON(tag)
 }
 
-// DeleteTag 删除标签
+// validateTag 检查标签名称和颜色，名称不能与该用户的其他标签重复（不区分大小写）
+func (h *TagHandler) validateTag(tag *models.Tag) error {
+	if tag.Name == "" {
+		return errTagNameRequired
+	}
+	if !hexColorPattern.MatchString(tag.Color) {
+		return errTagColorInvalid
+	}
+
+	var count int64
+	if err := h.db.Model(&models.Tag{}).
+		Where("user_id = ? AND LOWER(name) = LOWER(?) AND id <> ?", tag.UserID, tag.Name, tag.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errTagNameTaken
+	}
+	return nil
+}
+
+// renameTagSubtree 把名称为 oldName 的标签及其子标签改为以 newName 开头，
+// 改名后的名称不能与子树以外的标签重复
+func renameTagSubtree(tx *gorm.DB, userID uint, oldName, newName string) error {
+	if strings.HasPrefix(strings.ToLower(newName), strings.ToLower(oldName)+models.TagSeparator) {
+		return errTagIntoSubtree
+	}
+
+	var subtree []models.Tag
+	if err := tx.Where("user_id = ? AND "+tagSubtreeCondition, userID, oldName, oldName, oldName).Find(&subtree).Error; err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(subtree))
+	names := make(map[string]string, len(subtree))
+	for _, t := range subtree {
+		ids = append(ids, t.ID)
+		// 名称只在 ASCII 范围内忽略大小写，前缀的字符数不变
+		suffix := string([]rune(t.Name)[utf8.RuneCountInString(oldName):])
+		names[t.ID] = newName + suffix
+
+		var count int64
+		if err := tx.Model(&models.Tag{}).Where("user_id = ? AND LOWER(name) = LOWER(?)", userID, names[t.ID]).
+			Where("NOT "+tagSubtreeCondition, oldName, oldName, oldName).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errTagNameTaken
+		}
+	}
+
+	// 先改为临时名称，避免子树内部新旧名称互相冲突
+	if err := tx.Model(&models.Tag{}).Where("id IN ?", ids).Update("name", gorm.Expr("id")).Error; err != nil {
+		return err
+	}
+	for id, name := range names {
+		if err := tx.Model(&models.Tag{}).Where("id = ?", id).Update("name", name).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTagError 把标签校验错误转换为响应
+func writeTagError(ctx iris.Context, err error, message string) {
+	switch err {
+	case errTagNameRequired, errTagColorInvalid, errTagIntoSubtree:
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": err.Error()})
+	case errTagNameTaken:
+		ctx.StatusCode(iris.StatusConflict)
+		ctx.JSON(iris.Map{"error": err.Error()})
+	default:
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": message})
+	}
+}
+
+// MergeTags 把 source_ids 中的标签合并到路径中的标签：笔记改为使用目标标签，然后删除源标签
+func (h *TagHandler) MergeTags(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+	if userID == 0 {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(iris.Map{"error": "未授权访问"})
+		return
+	}
+
+	targetID := ctx.Params().Get("id")
+	var req MergeTagsRequest
+	if err := ctx.ReadJSON(&req); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "无效的请求数据"})
+		return
+	}
+
+	sourceIDs := make([]string, 0, len(req.SourceIDs))
+	seen := map[string]bool{targetID: true}
+	for _, id := range req.SourceIDs {
+		if !seen[id] {
+			seen[id] = true
+			sourceIDs = append(sourceIDs, id)
+		}
+	}
+	if len(sourceIDs) == 0 {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.JSON(iris.Map{"error": "请指定要合并的标签"})
+		return
+	}
+
+	var count int64
+	if err := h.db.Model(&models.Tag{}).Where("id IN ? AND user_id = ?", append(sourceIDs, targetID), userID).Count(&count).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取标签失败"})
+		return
+	}
+	if count != int64(len(sourceIDs)+1) {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "标签不存在"})
+		return
+	}
+
+	var merged int64
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		merged, err = mergeTags(tx, targetID, sourceIDs)
+		return err
+	}); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "合并标签失败"})
+		return
+	}
+
+	var tag models.Tag
+	if err := withTagNoteCount(h.db).Where("id = ?", targetID).First(&tag).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取标签失败"})
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"message":      "标签已合并",
+		"tag":          tag,
+		"merged_notes": merged,
+	})
+}
+
+// mergeTags 把源标签的笔记关联移动到目标标签并删除源标签，返回新增目标标签的笔记数量
+func mergeTags(tx *gorm.DB, targetID string, sourceIDs []string) (int64, error) {
+	result := tx.Exec(`INSERT INTO note_tags (note_id, tag_id)
+		SELECT DISTINCT note_id, ? FROM note_tags
+		WHERE tag_id IN ? AND note_id NOT IN (SELECT note_id FROM note_tags WHERE tag_id = ?)`,
+		targetID, sourceIDs, targetID)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if err := tx.Where("tag_id IN ?", sourceIDs).Delete(&models.NoteTag{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("id IN ?", sourceIDs).Delete(&models.Tag{}).Error; err != nil {
+		return 0, err
+	}
+	return result.RowsAffected, nil
+}
+
+// MergeDuplicateTags 合并同一用户下名称只有大小写不同的标签，保留最早创建的一个。
+// 在建立标签名称唯一索引之前调用，用于整理旧数据
+func MergeDuplicateTags(db *gorm.DB) error {
+	var groups []struct {
+		UserID uint
+		Name   string
+	}
+	if err := db.Model(&models.Tag{}).Select("user_id, LOWER(name) AS name").
+		Group("user_id, LOWER(name)").Having("COUNT(*) > 1").Scan(&groups).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, group := range groups {
+			var ids []string
+			if err := tx.Model(&models.Tag{}).Where("user_id = ? AND LOWER(name) = ?", group.UserID, group.Name).
+				Order("created_at").Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			if _, err := mergeTags(tx, ids[0], ids[1:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteUnusedTags 删除没有被任何笔记（包括回收站中的笔记）使用的标签
+func (h *TagHandler) DeleteUnusedTags(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+	if userID == 0 {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(iris.Map{"error": "未授权访问"})
+		return
+	}
+
+	var tags []models.Tag
+	if err := h.db.Where("user_id = ? AND NOT EXISTS (SELECT 1 FROM note_tags WHERE note_tags.tag_id = tags.id)", userID).
+		Order("name").Find(&tags).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "获取标签失败"})
+		return
+	}
+
+	if len(tags) > 0 {
+		if err := h.db.Delete(&tags).Error; err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.JSON(iris.Map{"error": "删除标签失败"})
+			return
+		}
+	}
+
+	ctx.JSON(iris.Map{
+		"message": "未使用的标签已清理",
+		"deleted": len(tags),
+		"tags":    tags,
+	})
+}
+
+// DeleteTag 删除标签，同时移除笔记上的该标签
 func (h *TagHandler) DeleteTag(ctx iris.Context) {
 	userID := ctx.Values().Get("userID").(uint)
 	if userID == 0 {
@@ -125,7 +460,12 @@ func (h *TagHandler) DeleteTag(ctx iris.Context) {
 		return
 	}
 
-	if err := h.db.Delete(&tag).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tag_id = ?", tag.ID).Delete(&models.NoteTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&tag).Error
+	}); err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.JSON(iris.Map{"error": "删除标签失败"})
 		return
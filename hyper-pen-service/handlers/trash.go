@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"hyper-pen-service/models"
+	"log"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+)
+
+// trashPurgeInterval 后台清理回收站的间隔
+const trashPurgeInterval = time.Hour
+
+// TrashHandler 处理回收站相关的请求
+type TrashHandler struct {
+	db          *gorm.DB
+	retention   time.Duration
+	attachments *AttachmentHandler
+}
+
+// NewTrashHandler 创建新的回收站处理器，retentionDays 为 0 时不自动清理；永久删除笔记时通过 attachments 回收附件内容
+func NewTrashHandler(db *gorm.DB, retentionDays int, attachments *AttachmentHandler) *TrashHandler {
+	return &TrashHandler{
+		db:          db,
+		retention:   time.Duration(retentionDays) * 24 * time.Hour,
+		attachments: attachments,
+	}
+}
+
+// TrashedNote 回收站中的笔记
+type TrashedNote struct {
+	models.Note
+	PurgeAt *time.Time `json:"purge_at,omitempty"`
+}
+
+// GetTrash 获取回收站中的笔记
+func (h *TrashHandler) GetTrash(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	var notes []models.Note
+	if err := h.db.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at desc").Preload("Tags").Preload("Category").Find(&notes).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch trash"})
+		return
+	}
+
+	trashed := make([]TrashedNote, 0, len(notes))
+	for _, note := range notes {
+		item := TrashedNote{Note: note}
+		if h.retention > 0 {
+			purgeAt := note.DeletedAt.Time.Add(h.retention)
+			item.PurgeAt = &purgeAt
+		}
+		trashed = append(trashed, item)
+	}
+
+	ctx.JSON(trashed)
+}
+
+// RestoreNote 从回收站恢复笔记，并去掉已不存在的标签。随分类删除的笔记按原路径重新创建分类，
+// 其他分类已被删除的笔记恢复为未分类
+func (h *TrashHandler) RestoreNote(ctx iris.Context) {
+	id := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	var note models.Note
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID).First(&note).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"deleted_at": nil, "deleted_category_path": ""}
+		if names := splitCategoryPath(note.DeletedCategoryPath); note.CategoryID == "" && len(names) > 0 {
+			categoryID, err := findOrCreateCategoryPath(tx, userID, names)
+			if err != nil {
+				return err
+			}
+			updates["category_id"] = categoryID
+		} else if note.CategoryID != "" {
+			var count int64
+			if err := tx.Model(&models.Category{}).Where("id = ? AND user_id = ?", note.CategoryID, userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				updates["category_id"] = ""
+			}
+		}
+		if err := tx.Unscoped().Model(&note).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("note_id = ? AND tag_id NOT IN (?)", note.ID, tx.Model(&models.Tag{}).Select("id")).
+			Delete(&models.NoteTag{}).Error; err != nil {
+			return err
+		}
+
+		// 笔记在回收站期间写下的指向它的链接
+		if err := resolveDanglingLinks(tx, userID); err != nil {
+			return err
+		}
+		return refreshBrokenLinks(tx, userID)
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.JSON(iris.Map{"error": "Note not found in trash"})
+			return
+		}
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to restore note"})
+		return
+	}
+
+	if err := h.db.Where("id = ?", note.ID).Preload("Tags").Preload("Category").First(&note).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to load note"})
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"message": "Note restored successfully",
+		"note":    note,
+	})
+}
+
+// PurgeNote 从回收站中永久删除笔记
+func (h *TrashHandler) PurgeNote(ctx iris.Context) {
+	id := ctx.Params().Get("id")
+	userID := ctx.Values().Get("userID").(uint)
+
+	var ids []string
+	if err := h.db.Unscoped().Model(&models.Note{}).
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID).Pluck("id", &ids).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch note"})
+		return
+	}
+	if len(ids) == 0 {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.JSON(iris.Map{"error": "Note not found in trash"})
+		return
+	}
+
+	if err := h.purge(ids); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to purge note"})
+		return
+	}
+
+	ctx.JSON(iris.Map{"message": "Note permanently deleted"})
+}
+
+// EmptyTrash 清空回收站
+func (h *TrashHandler) EmptyTrash(ctx iris.Context) {
+	userID := ctx.Values().Get("userID").(uint)
+
+	var ids []string
+	if err := h.db.Unscoped().Model(&models.Note{}).
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).Pluck("id", &ids).Error; err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to fetch trash"})
+		return
+	}
+
+	if err := h.purge(ids); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.JSON(iris.Map{"error": "Failed to empty trash"})
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"message": "Trash emptied",
+		"purged":  len(ids),
+	})
+}
+
+// StartPurger 启动后台任务，定期永久删除超过保留期的笔记
+func (h *TrashHandler) StartPurger() {
+	if h.retention <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := h.purgeExpired(); err != nil {
+				log.Printf("清理回收站失败: %v", err)
+			} else if n > 0 {
+				log.Printf("已从回收站永久删除 %d 篇笔记", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// purgeExpired 永久删除所有超过保留期的笔记
+func (h *TrashHandler) purgeExpired() (int, error) {
+	var ids []string
+	if err := h.db.Unscoped().Model(&models.Note{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-h.retention)).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	return len(ids), h.purge(ids)
+}
+
+// purge 在事务中永久删除笔记，提交后回收不再被引用的附件内容
+func (h *TrashHandler) purge(ids []string) error {
+	var hashes []string
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		hashes, err = purgeNotes(tx, ids)
+		return err
+	}); err != nil {
+		return err
+	}
+	h.attachments.RemoveUnreferenced(hashes)
+	return nil
+}
+
+// purgeNotes 永久删除笔记及其标签关联、分享链接、协作者、修订版本、附件和发出的链接，指向这些笔记的链接变为未解析，
+// 返回被删除附件的内容哈希
+func purgeNotes(tx *gorm.DB, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var hashes []string
+	if err := tx.Model(&models.Attachment{}).Where("note_id IN ?", ids).Distinct().Pluck("hash", &hashes).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("note_id IN ?", ids).Delete(&models.Attachment{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("note_id IN ?", ids).Delete(&models.NoteTag{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("share_link_id IN (?)", tx.Model(&models.ShareLink{}).Select("id").Where("note_id IN ?", ids)).
+		Delete(&models.ShareAccessLog{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("note_id IN ?", ids).Delete(&models.ShareLink{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("note_id IN ?", ids).Delete(&models.Permission{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("note_id IN ?", ids).Delete(&models.NoteRevision{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("source_id IN ?", ids).Delete(&models.NoteLink{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.NoteLink{}).Where("target_id IN ?", ids).Update("target_id", "").Error; err != nil {
+		return nil, err
+	}
+	var userIDs []uint
+	if err := tx.Unscoped().Model(&models.Note{}).Where("id IN ?", ids).Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Unscoped().Where("id IN ?", ids).Delete(&models.Note{}).Error; err != nil {
+		return nil, err
+	}
+	for _, userID := range userIDs {
+		if err := refreshBrokenLinks(tx, userID); err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
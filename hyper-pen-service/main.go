@@ -1,9 +1,17 @@
 package main
 
 import (
+	"flag"
+	"hyper-pen-service/config"
 	"hyper-pen-service/handlers"
 	"hyper-pen-service/middleware"
 	"hyper-pen-service/models"
+	"hyper-pen-service/oauth"
+	"hyper-pen-service/render"
+	"hyper-pen-service/search"
+	"hyper-pen-service/storage"
+	"log"
+	"strings"
 
 	"github.com/kataras/iris/v12"
 	"gorm.io/driver/sqlite"
@@ -11,6 +19,11 @@ import (
 )
 
 func main() {
+	rebuildSearchIndex := flag.Bool("rebuild-search-index", false, "重建全文索引后退出")
+	flag.Parse()
+
+	config.LoadConfig()
+
 	app := iris.New()
 
 	// 连接数据库
@@ -20,14 +33,76 @@ func main() {
 	}
 
 	// 自动迁移数据库表
-	db.AutoMigrate(&models.User{}, &models.Note{}, &models.Category{}, &models.Tag{}, &models.ShareLink{})
+	linksMigrated := db.Migrator().HasTable(&models.NoteLink{})
+	// 标签名称按用户唯一，建立唯一索引前先合并已有的重名标签
+	if db.Migrator().HasTable(&models.Tag{}) {
+		if err := handlers.MergeDuplicateTags(db); err != nil {
+			log.Printf("合并重名标签失败: %v", err)
+		}
+	}
+	db.AutoMigrate(&models.User{}, &models.Note{}, &models.Category{}, &models.Tag{}, &models.ShareLink{}, &models.NoteRevision{}, &models.Attachment{}, &models.NoteLink{}, &models.Permission{}, &models.ShareAccessLog{}, &models.PendingRegistration{}, &models.Identity{})
+
+	// 早期版本创建分享链接时没有生成ID
+	if err := handlers.BackfillShareLinkIDs(db); err != nil {
+		log.Printf("补全分享链接ID失败: %v", err)
+	}
+	// 第三方帐户改为保存在 identities 表中
+	if err := handlers.MigrateLegacyIdentities(db); err != nil {
+		log.Printf("迁移第三方帐户绑定失败: %v", err)
+	}
+
+	// 首次启用双链时回填已有笔记的链接
+	if !linksMigrated {
+		if err := handlers.RebuildNoteLinks(db); err != nil {
+			log.Printf("回填笔记双链失败: %v", err)
+		}
+	}
+
+	// 创建全文索引，SQLite 未启用 FTS5 时搜索退回到 LIKE
+	if err := search.Setup(db); err != nil {
+		log.Printf("全文索引不可用，请使用 -tags sqlite_fts5 编译: %v", err)
+	}
+	if *rebuildSearchIndex {
+		count, err := search.Rebuild(db)
+		if err != nil {
+			log.Fatalf("重建全文索引失败: %v", err)
+		}
+		log.Printf("已重建全文索引，共 %d 篇笔记", count)
+		return
+	}
+
+	// 附件存储
+	attachmentStore, err := storage.NewLocalStorage(config.AppConfig.AttachmentDir)
+	if err != nil {
+		log.Fatalf("创建附件存储目录失败: %v", err)
+	}
+
+	// 第三方身份提供方，配置了 OIDC issuer 时启用 OIDC 登录
+	providers := []oauth.IdentityProvider{
+		oauth.NewGitHubProvider(config.AppConfig.GitHubClientID, config.AppConfig.GitHubClientSecret, config.AppConfig.GitHubRedirectURI),
+		oauth.NewWechatProvider(config.AppConfig.WechatAppID, config.AppConfig.WechatAppSecret, config.AppConfig.WechatRedirectURI),
+	}
+	if config.AppConfig.OIDCIssuerURL != "" {
+		providers = append(providers, oauth.NewOIDCProvider(config.AppConfig.OIDCName, config.AppConfig.OIDCIssuerURL,
+			config.AppConfig.OIDCClientID, config.AppConfig.OIDCClientSecret, config.AppConfig.OIDCRedirectURI,
+			strings.Fields(config.AppConfig.OIDCScopes)))
+	}
 
 	// 创建处理器
-	authHandler := handlers.NewAuthHandler(db)
-	noteHandler := handlers.NewNoteHandler(db)
-	shareHandler := handlers.NewShareHandler(db)
+	authHandler := handlers.NewAuthHandler(db, providers...)
+	renderer := render.NewRenderer(config.AppConfig.RenderCacheSize)
+	noteHandler := handlers.NewNoteHandler(db, renderer)
+	shareHandler := handlers.NewShareHandler(db, renderer)
 	tagHandler := handlers.NewTagHandler(db)
 	categoryHandler := handlers.NewCategoryHandler(db)
+	accountHandler := handlers.NewAccountHandler(db)
+	collabHandler := handlers.NewCollabHandler(db, noteHandler)
+	permissionHandler := handlers.NewPermissionHandler(db, collabHandler)
+	attachmentHandler := handlers.NewAttachmentHandler(db, attachmentStore, int64(config.AppConfig.MaxAttachmentSizeMB)<<20)
+	trashHandler := handlers.NewTrashHandler(db, config.AppConfig.TrashRetentionDays, attachmentHandler)
+
+	// 定期清理回收站
+	trashHandler.StartPurger()
 
 	// 注册路由
 	api := app.Party("/api")
@@ -36,11 +111,14 @@ func main() {
 		auth := api.Party("/auth")
 		{
 			auth.Post("/register", authHandler.Register)
+			auth.Post("/register/complete", authHandler.CompleteRegistration)
 			auth.Post("/login", authHandler.Login)
-			auth.Get("/github", authHandler.GitHubOAuthLogin)
-			auth.Get("/github/callback", authHandler.GitHubOAuthCallback)
-			auth.Get("/wechat", authHandler.WechatLogin)
-			auth.Get("/wechat/callback", authHandler.WechatCallback)
+			auth.Post("/exchange", authHandler.ExchangeCode)
+			auth.Get("/providers", authHandler.Providers)
+			auth.Get("/wechat/login", authHandler.WechatQRLogin)
+			auth.Get("/wechat/check", authHandler.WechatLoginStatus)
+			auth.Get("/{provider:string}", authHandler.OAuthLogin)
+			auth.Get("/{provider:string}/callback", authHandler.OAuthCallback)
 		}
 
 		// 笔记相关路由
@@ -49,21 +127,58 @@ func main() {
 		{
 			notes.Get("", noteHandler.GetNotes)
 			notes.Post("", noteHandler.CreateNote)
+			notes.Get("/shared-with-me", noteHandler.GetSharedNotes)
 			notes.Get("/{id:string}", noteHandler.GetNote)
 			notes.Put("/{id:string}", noteHandler.UpdateNote)
 			notes.Delete("/{id:string}", noteHandler.DeleteNote)
 			notes.Get("/search", noteHandler.SearchNotes)
+			notes.Get("/search/parse", noteHandler.ParseSearchQuery)
+			notes.Post("/import", noteHandler.ImportNotes)
+			notes.Get("/export", noteHandler.ExportNotes)
+			notes.Get("/{id:string}/export", noteHandler.ExportNote)
+
+			// 双链相关路由
+			notes.Get("/graph", noteHandler.GetGraph)
+			notes.Get("/links/unresolved", noteHandler.GetUnresolvedLinks)
+			notes.Get("/{id:string}/backlinks", noteHandler.GetBacklinks)
+
+			// 修订历史相关路由
+			notes.Get("/{id:string}/revisions", noteHandler.GetRevisions)
+			notes.Get("/{id:string}/revisions/diff", noteHandler.DiffRevisions)
+			notes.Get("/{id:string}/revisions/{number:int}", noteHandler.GetRevision)
+			notes.Post("/{id:string}/revisions/{number:int}/restore", noteHandler.RestoreRevision)
+
+			// 实时协同编辑
+			notes.Post("/{id:string}/collab/ticket", collabHandler.IssueTicket)
+
+			// 附件相关路由
+			notes.Get("/{id:string}/attachments", attachmentHandler.GetAttachments)
+			notes.Post("/{id:string}/attachments", attachmentHandler.UploadAttachments)
+			notes.Delete("/{id:string}/attachments/{attachmentId:string}", attachmentHandler.DeleteAttachment)
 
 			// 分享相关路由
 			notes.Get("/{id:string}/share-links", shareHandler.GetShareLinks)
 			notes.Post("/{id:string}/share-links", shareHandler.CreateShareLink)
+			notes.Delete("/{id:string}/share-links", shareHandler.RevokeShareLinks)
+
+			// 协作者相关路由
+			notes.Get("/{id:string}/permissions", permissionHandler.GetNotePermissions)
+			notes.Post("/{id:string}/permissions", permissionHandler.ShareNote)
 		}
 
+		// 协同编辑的 WebSocket 连接，浏览器握手时无法设置请求头，由处理器校验一次性票据
+		api.Get("/notes/{id:string}/collab", middleware.OptionalAuth, collabHandler.Connect)
+
+		// 附件下载，笔记所有者或持有分享 token 的访问者均可下载
+		api.Get("/notes/{id:string}/attachments/{attachmentId:string}", middleware.OptionalAuth, attachmentHandler.DownloadAttachment)
+
 		// 分享链接相关路由
 		shareLinks := api.Party("/share-links")
 		shareLinks.Use(middleware.AuthRequired)
 		{
+			shareLinks.Put("/{id:string}", shareHandler.UpdateShareLink)
 			shareLinks.Delete("/{id:string}", shareHandler.DeleteShareLink)
+			shareLinks.Get("/{id:string}/access-log", shareHandler.GetShareAccessLog)
 		}
 
 		// 标签相关路由
@@ -72,6 +187,8 @@ func main() {
 		{
 			tags.Get("", tagHandler.GetTags)
 			tags.Post("", tagHandler.CreateTag)
+			tags.Delete("/unused", tagHandler.DeleteUnusedTags)
+			tags.Post("/{id:string}/merge", tagHandler.MergeTags)
 			tags.Put("/{id:string}", tagHandler.UpdateTag)
 			tags.Delete("/{id:string}", tagHandler.DeleteTag)
 		}
@@ -84,10 +201,51 @@ func main() {
 			categories.Post("", categoryHandler.CreateCategory)
 			categories.Put("/{id:string}", categoryHandler.UpdateCategory)
 			categories.Delete("/{id:string}", categoryHandler.DeleteCategory)
+			categories.Post("/{id:string}/move", categoryHandler.MoveCategory)
+			categories.Get("/{id:string}/permissions", permissionHandler.GetCategoryPermissions)
+			categories.Post("/{id:string}/permissions", permissionHandler.ShareCategory)
+			categories.Get("/{id:string}/share-links", shareHandler.GetCategoryShareLinks)
+			categories.Post("/{id:string}/share-links", shareHandler.CreateCategoryShareLink)
+			categories.Delete("/{id:string}/share-links", shareHandler.RevokeCategoryShareLinks)
+		}
+
+		// 协作权限相关路由
+		permissions := api.Party("/permissions")
+		permissions.Use(middleware.AuthRequired)
+		{
+			permissions.Get("/received", permissionHandler.GetReceivedPermissions)
+			permissions.Put("/{id:string}", permissionHandler.UpdatePermission)
+			permissions.Delete("/{id:string}", permissionHandler.DeletePermission)
+		}
+
+		// 回收站相关路由
+		trash := api.Party("/trash")
+		trash.Use(middleware.AuthRequired)
+		{
+			trash.Get("", trashHandler.GetTrash)
+			trash.Delete("", trashHandler.EmptyTrash)
+			trash.Post("/{id:string}/restore", trashHandler.RestoreNote)
+			trash.Delete("/{id:string}", trashHandler.PurgeNote)
+		}
+
+		// 帐户设置相关路由
+		account := api.Party("/account")
+		account.Use(middleware.AuthRequired)
+		{
+			account.Get("/revision-retention", accountHandler.GetRevisionRetention)
+			account.Put("/revision-retention", accountHandler.UpdateRevisionRetention)
+			account.Get("/identities", accountHandler.GetIdentities)
+			account.Post("/identities/{provider:string}", authHandler.LinkIdentity)
+			account.Delete("/identities/{provider:string}", accountHandler.UnlinkIdentity)
 		}
 
 		// 共享笔记路由（不需要认证）
 		api.Get("/shared/{token:string}", shareHandler.GetSharedNote)
+		api.Post("/shared/{token:string}", shareHandler.GetSharedNote)
+		api.Get("/shared/{token:string}/notes", shareHandler.GetSharedCategory)
+		api.Post("/shared/{token:string}/notes", shareHandler.GetSharedCategory)
+		api.Get("/shared/{token:string}/notes/{noteId:string}", shareHandler.GetSharedCategoryNote)
+		api.Post("/shared/{token:string}/notes/{noteId:string}", shareHandler.GetSharedCategoryNote)
 	}
 
 	app.Listen(":8080")
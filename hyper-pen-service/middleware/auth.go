@@ -29,8 +29,25 @@ func AuthRequired(ctx iris.Context) {
 		ctx.JSON(iris.Map{"error": "Invalid token"})
 		return
 	}
+	// 待完成注册的受限令牌不能访问其他接口
+	if claims.RegistrationID != "" {
+		ctx.StatusCode(iris.StatusUnauthorized)
+		ctx.JSON(iris.Map{"error": "Registration is not completed", "registration_required": true})
+		return
+	}
 
 	// 将用户ID存储到上下文中
 	ctx.Values().Set("userID", claims.UserID)
 	ctx.Next()
 }
+
+// OptionalAuth 请求携带有效 token 时记录用户ID，否则以匿名身份继续，由处理器自行判断权限
+func OptionalAuth(ctx iris.Context) {
+	authHeader := ctx.GetHeader("Authorization")
+	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
+		if claims, err := utils.ParseToken(authHeader[7:]); err == nil && claims.RegistrationID == "" {
+			ctx.Values().Set("userID", claims.UserID)
+		}
+	}
+	ctx.Next()
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// Attachment 笔记附件，文件内容按 Hash 存储，多个附件可以共享同一份内容
+type Attachment struct {
+	ID          string    `json:"id" gorm:"primaryKey"`
+	UserID      uint      `json:"user_id" gorm:"not null;index"`
+	NoteID      string    `json:"note_id" gorm:"not null;index"`
+	FileName    string    `json:"file_name" gorm:"not null"`
+	ContentType string    `json:"content_type" gorm:"not null"`
+	Size        int64     `json:"size"`
+	Hash        string    `json:"hash" gorm:"size:64;not null;index"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -5,10 +5,18 @@ import (
 )
 
 type Category struct {
-	ID        string    `json:"id" gorm:"primaryKey"`
-	Name      string    `json:"name" gorm:"not null"`
+	ID   string `json:"id" gorm:"primaryKey"`
+	Name string `json:"name" gorm:"not null"`
+	// ParentID 上级分类，为空表示顶级分类
+	ParentID  string    `json:"parent_id" gorm:"index"`
 	UserID    uint      `json:"user_id" gorm:"not null"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Notes     []Note    `json:"notes,omitempty" gorm:"foreignKey:CategoryID"`
+	// NoteCount 分类中未删除的笔记数量，只在查询时计算
+	NoteCount int64 `json:"note_count" gorm:"->;-:migration"`
+	// Children 以树形返回时的子分类
+	Children []*Category `json:"children,omitempty" gorm:"-"`
+	// Path 导出时从顶级分类到自身的路径，各级名称以 / 分隔
+	Path string `json:"path,omitempty" gorm:"-"`
 }
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// 第三方登录提供方
+const (
+	ProviderGitHub = "github"
+	ProviderWechat = "wechat"
+)
+
+// Identity 用户绑定的第三方帐户。同一个第三方帐户只能绑定一个用户，每个用户每个提供方只能绑定一个帐户
+type Identity struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	UserID     uint   `json:"-" gorm:"not null;uniqueIndex:idx_identities_user_provider"`
+	Provider   string `json:"provider" gorm:"not null;uniqueIndex:idx_identities_user_provider;uniqueIndex:idx_identities_provider_account"`
+	ProviderID string `json:"provider_id" gorm:"not null;uniqueIndex:idx_identities_provider_account"`
+	// Name 第三方帐户的显示名称
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
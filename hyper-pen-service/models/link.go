@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// NoteLink 笔记之间的双链。TargetTitle 为链接中书写的目标（标题或笔记ID），
+// TargetID 为解析到的笔记，为空表示目标笔记尚不存在
+type NoteLink struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	UserID      uint      `json:"user_id" gorm:"not null;index"`
+	SourceID    string    `json:"source_id" gorm:"not null;index"`
+	TargetID    string    `json:"target_id" gorm:"index"`
+	TargetTitle string    `json:"target_title" gorm:"not null"`
+	Alias       string    `json:"alias,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
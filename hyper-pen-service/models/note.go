@@ -2,17 +2,9 @@ package models
 
 import (
 	"time"
-)
 
-// ShareLink 分享链接模型
-type ShareLink struct {
-	ID        string    `json:"id" gorm:"primaryKey"`
-	NoteID    string    `json:"note_id" gorm:"not null"`
-	Token     string    `json:"token" gorm:"unique;not null"`
-	ExpiresAt time.Time `json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-}
+	"gorm.io/gorm"
+)
 
 // Note 笔记模型
 type Note struct {
@@ -21,11 +13,24 @@ type Note struct {
 	CategoryID string      `json:"category_id"`
 	Title      string      `json:"title" gorm:"not null"`
 	Content    string      `json:"content" gorm:"type:text;not null"`
+	Version    int         `json:"version" gorm:"not null;default:1"`
 	Category   *Category   `json:"category,omitempty" gorm:"foreignKey:CategoryID"`
 	Tags       []Tag       `json:"tags,omitempty" gorm:"many2many:note_tags;"`
 	ShareLinks []ShareLink `json:"share_links,omitempty" gorm:"foreignKey:NoteID"`
 	CreatedAt  time.Time   `json:"created_at"`
 	UpdatedAt  time.Time   `json:"updated_at"`
+	// HasBrokenLinks 正文中存在目标笔记不存在或已移入回收站的双链
+	HasBrokenLinks bool `json:"has_broken_links" gorm:"not null;default:false"`
+	// Role 当前用户对笔记的角色，只在获取单个笔记时返回
+	Role string `json:"role,omitempty" gorm:"-"`
+	// HTML 渲染并清理后的正文，只在请求 format=html 时返回
+	HTML string `json:"html,omitempty" gorm:"-"`
+	// AttachmentQuery 加在附件地址后的短期凭证，使 <img> 等无法携带 Authorization 请求头的请求也能下载附件，只在获取单个笔记时返回
+	AttachmentQuery string `json:"attachment_query,omitempty" gorm:"-"`
+	// DeletedCategoryPath 随分类删除移入回收站时原分类的路径，恢复笔记时按路径重新创建分类
+	DeletedCategoryPath string `json:"deleted_category_path,omitempty"`
+	// DeletedAt 非空表示笔记在回收站中
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
 }
 
 // NoteTag 笔记标签关联表
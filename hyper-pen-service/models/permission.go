@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// 协作者角色，权限依次增加；RoleOwner 只用于表示笔记所有者，不会保存到 Permission 中。
+// 还没有评论功能，RoleCommenter 目前与 RoleViewer 的权限相同
+const (
+	RoleViewer    = "viewer"
+	RoleCommenter = "commenter"
+	RoleEditor    = "editor"
+	RoleOwner     = "owner"
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:    1,
+	RoleCommenter: 2,
+	RoleEditor:    3,
+	RoleOwner:     4,
+}
+
+// ValidRole 角色是否可以授予其他用户
+func ValidRole(role string) bool {
+	return role == RoleViewer || role == RoleCommenter || role == RoleEditor
+}
+
+// RoleAtLeast 角色 role 是否至少具有 min 的权限，空角色表示没有权限
+func RoleAtLeast(role, min string) bool {
+	return roleLevels[role] > 0 && roleLevels[role] >= roleLevels[min]
+}
+
+// Permission 笔记或分类授予其他用户的访问权限，NoteID 和 CategoryID 只有一个非空。
+// 分类的权限同样作用于其子孙分类中的笔记
+type Permission struct {
+	ID         string    `json:"id" gorm:"primaryKey"`
+	OwnerID    uint      `json:"owner_id" gorm:"not null;index"`
+	UserID     uint      `json:"user_id" gorm:"not null;index"`
+	NoteID     string    `json:"note_id,omitempty" gorm:"index"`
+	CategoryID string    `json:"category_id,omitempty" gorm:"index"`
+	Role       string    `json:"role" gorm:"not null"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// PendingRegistration 首次使用第三方帐户登录时待完成的注册，保存第三方帐户信息，
+// 用户选择用户名和邮箱完成注册后删除
+type PendingRegistration struct {
+	ID         string `json:"id" gorm:"primaryKey"`
+	Provider   string `json:"provider" gorm:"not null"`
+	ProviderID string `json:"-" gorm:"not null"`
+	// Username、Email、AvatarURL 来自第三方帐户，作为注册时的建议值
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+	// AccessToken 第三方访问令牌，完成注册时保存到用户
+	AccessToken string    `json:"-"`
+	ExpiresAt   time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt   time.Time `json:"created_at"`
+}
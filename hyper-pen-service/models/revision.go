@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// NoteRevision 笔记修订版本，每次保存笔记时记录一条
+type NoteRevision struct {
+	ID      string `json:"id" gorm:"primaryKey"`
+	NoteID  string `json:"note_id" gorm:"not null;index:idx_note_revision,unique,priority:1"`
+	Number  int    `json:"number" gorm:"not null;index:idx_note_revision,unique,priority:2"`
+	Version int    `json:"version" gorm:"not null;default:1"`
+	UserID  uint   `json:"user_id" gorm:"not null"`
+	// EditorID 保存该版本的用户，协作者编辑时与所有者 UserID 不同
+	EditorID   uint      `json:"editor_id"`
+	Title      string    `json:"title" gorm:"not null"`
+	Content    string    `json:"content,omitempty" gorm:"type:text;not null"`
+	CategoryID string    `json:"category_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+// 分享链接访问结果
+const (
+	ShareAccessViewed           = "viewed"
+	ShareAccessListed           = "listed"
+	ShareAccessPasswordRequired = "password_required"
+	ShareAccessWrongPassword    = "wrong_password"
+	ShareAccessLimitReached     = "limit_reached"
+)
+
+// ShareLink 分享链接模型，NoteID 和 CategoryID 只有一个非空。
+// 分享分类时，分类及其子孙分类中的笔记（包括之后加入的笔记）都可以通过链接访问
+type ShareLink struct {
+	ID         string `json:"id" gorm:"primaryKey"`
+	NoteID     string `json:"note_id" gorm:"not null;index"`
+	CategoryID string `json:"category_id,omitempty" gorm:"index"`
+	Token      string `json:"token" gorm:"unique;not null"`
+	// PasswordHash 访问密码的 bcrypt 哈希，为空表示不需要密码
+	PasswordHash string `json:"-"`
+	// MaxViews 最多允许访问的次数，0 表示不限制
+	MaxViews  int       `json:"max_views" gorm:"not null;default:0"`
+	ViewCount int       `json:"view_count" gorm:"not null;default:0"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ShareAccessLog 分享链接的访问记录，IP 只保存哈希
+type ShareAccessLog struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	ShareLinkID string `json:"share_link_id" gorm:"not null;index"`
+	// NoteID 访问的笔记，访问分类的笔记列表时为空
+	NoteID     string    `json:"note_id,omitempty"`
+	IPHash     string    `json:"ip_hash"`
+	UserAgent  string    `json:"user_agent"`
+	Result     string    `json:"result" gorm:"not null"`
+	AccessedAt time.Time `json:"accessed_at" gorm:"index"`
+}
@@ -1,15 +1,48 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
+// TagSeparator 层级标签的分隔符，例如 lang/go 是 lang 的子标签
+const TagSeparator = "/"
+
 type Tag struct {
 	ID        string    `json:"id" gorm:"primaryKey"`
-	Name      string    `json:"name" gorm:"size:255;not null"`
+	Name      string    `json:"name" gorm:"size:255;not null;uniqueIndex:idx_tags_user_name,priority:2,collate:NOCASE"`
 	Color     string    `json:"color" gorm:"not null"`
-	UserID    uint      `json:"user_id" gorm:"size:36;not null"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_tags_user_name,priority:1"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Notes     []Note    `json:"notes" gorm:"many2many:note_tags;"`
+	// NoteCount 使用该标签的未删除笔记数量，只在查询时计算
+	NoteCount int64 `json:"note_count" gorm:"->;-:migration"`
+	// Children 以树形返回时的子标签
+	Children []*Tag `json:"children,omitempty" gorm:"-"`
+}
+
+// ParentName 返回上级标签的名称，顶级标签返回空字符串。上级标签不一定存在
+func (t *Tag) ParentName() string {
+	return ParentTagName(t.Name)
+}
+
+// ParentTagName 返回标签名称 name 的上级名称，例如 lang/go 返回 lang
+func ParentTagName(name string) string {
+	if i := strings.LastIndex(name, TagSeparator); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// NormalizeTagName 去掉每一级名称两端的空白并忽略空的层级，例如 " lang / go/ " 规范为 "lang/go"
+func NormalizeTagName(name string) string {
+	parts := strings.Split(name, TagSeparator)
+	segments := parts[:0]
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			segments = append(segments, part)
+		}
+	}
+	return strings.Join(segments, TagSeparator)
 }
@@ -5,14 +5,15 @@ import (
 )
 
 type User struct {
-	ID          uint      `json:"id" gorm:"primaryKey"`
-	Username    string    `json:"username" gorm:"unique;not null"`
-	Password    string    `json:"-" gorm:"not null"`
-	Email       string    `json:"email" gorm:"unique;not null"`
-	GithubID    string    `json:"github_id" gorm:"unique"`
-	WechatID    string    `json:"wechat_id" gorm:"unique"`
-	AvatarURL   string    `json:"avatar_url"`
-	GitHubToken string    `json:"-"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Username    string `json:"username" gorm:"unique;not null"`
+	Password    string `json:"-" gorm:"not null"`
+	Email       string `json:"email" gorm:"unique;not null"`
+	AvatarURL   string `json:"avatar_url"`
+	GitHubToken string `json:"-"`
+	// 修订版本保留策略，0 表示不限制
+	RevisionKeepCount int       `json:"revision_keep_count" gorm:"not null;default:0"`
+	RevisionKeepDays  int       `json:"revision_keep_days" gorm:"not null;default:0"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
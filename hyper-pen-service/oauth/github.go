@@ -0,0 +1,102 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	githubAuthorizeURL = "https://github.com/login/oauth/authorize"
+	githubTokenURL     = "https://github.com/login/oauth/access_token"
+	githubUserURL      = "https://api.github.com/user"
+)
+
+// GitHubProvider GitHub OAuth 应用
+type GitHubProvider struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+}
+
+// NewGitHubProvider 创建 GitHub 身份提供方
+func NewGitHubProvider(clientID, clientSecret, redirectURI string) *GitHubProvider {
+	return &GitHubProvider{ClientID: clientID, ClientSecret: clientSecret, RedirectURI: redirectURI}
+}
+
+func (p *GitHubProvider) Name() string {
+	return "github"
+}
+
+// AuthURL 构建 GitHub 授权地址，带 state 和 PKCE code challenge
+func (p *GitHubProvider) AuthURL(req AuthRequest) (string, error) {
+	params := url.Values{}
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURI)
+	params.Set("scope", "user:email")
+	params.Set("state", req.State)
+	params.Set("code_challenge", req.CodeChallenge())
+	params.Set("code_challenge_method", "S256")
+	return githubAuthorizeURL + "?" + params.Encode(), nil
+}
+
+// Exchange 用授权码换取 GitHub 访问令牌
+func (p *GitHubProvider) Exchange(ctx context.Context, code string, req AuthRequest) (*Token, error) {
+	data := url.Values{}
+	data.Set("client_id", p.ClientID)
+	data.Set("client_secret", p.ClientSecret)
+	data.Set("code", code)
+	data.Set("redirect_uri", p.RedirectURI)
+	data.Set("code_verifier", req.CodeVerifier)
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, githubTokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := doJSON(httpReq, &result); err != nil {
+		return nil, err
+	}
+	// 授权码无效时 GitHub 返回 error 而不是 access_token
+	if result.AccessToken == "" {
+		return nil, fmt.Errorf("github: %s %s", result.Error, result.ErrorDescription)
+	}
+	return &Token{AccessToken: result.AccessToken}, nil
+}
+
+// FetchProfile 获取 GitHub 用户信息
+func (p *GitHubProvider) FetchProfile(ctx context.Context, token *Token) (*Profile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, githubUserURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Email     string `json:"email"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := doJSON(req, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, fmt.Errorf("github: user id not found")
+	}
+	return &Profile{
+		ID:        strconv.FormatInt(user.ID, 10),
+		Name:      user.Login,
+		Email:     user.Email,
+		AvatarURL: user.AvatarURL,
+	}, nil
+}
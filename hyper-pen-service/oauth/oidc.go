@@ -0,0 +1,300 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcLeeway 校验 ID Token 有效期时允许的时钟误差
+const oidcLeeway = time.Minute
+
+// OIDCProvider 通用 OpenID Connect 提供方（例如 Keycloak），通过 issuer 的 discovery 文档获取端点和签名公钥。
+// 使用授权码流程，校验 state、PKCE 和 ID Token 的签名、issuer、audience 与 nonce
+type OIDCProvider struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURI  string
+	scopes       []string
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// oidcDiscovery issuer 的 /.well-known/openid-configuration 文档中用到的字段
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDCProvider 创建 OIDC 身份提供方。discovery 文档在第一次使用时获取，issuer 暂时不可用不影响服务启动
+func NewOIDCProvider(name, issuer, clientID, clientSecret, redirectURI string, scopes []string) *OIDCProvider {
+	return &OIDCProvider{
+		name:         name,
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURI:  redirectURI,
+		scopes:       scopes,
+	}
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+// discover 获取并缓存 discovery 文档，文档中的 issuer 必须与配置一致
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	cached := p.discovery
+	p.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	var doc oidcDiscovery
+	if err := getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("oidc: issuer mismatch: expected %q, got %q", p.issuer, doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+
+	p.mu.Lock()
+	p.discovery = &doc
+	p.mu.Unlock()
+	return &doc, nil
+}
+
+// AuthURL 构建授权地址，带 state、nonce 和 PKCE code challenge
+func (p *OIDCProvider) AuthURL(req AuthRequest) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.clientID)
+	params.Set("redirect_uri", p.redirectURI)
+	params.Set("scope", strings.Join(p.scopes, " "))
+	params.Set("state", req.State)
+	params.Set("nonce", req.Nonce)
+	params.Set("code_challenge", req.CodeChallenge())
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange 用授权码和 code verifier 换取令牌，并校验返回的 ID Token
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, req AuthRequest) (*Token, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", p.redirectURI)
+	data.Set("client_id", p.clientID)
+	data.Set("client_secret", p.clientSecret)
+	data.Set("code_verifier", req.CodeVerifier)
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := doJSON(httpReq, &result); err != nil {
+		return nil, err
+	}
+	if result.IDToken == "" {
+		return nil, errors.New("oidc: id_token not found in token response")
+	}
+
+	claims, err := p.verifyIDToken(ctx, doc, result.IDToken, req.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("oidc: id_token has no subject")
+	}
+	return &Token{AccessToken: result.AccessToken, Subject: sub, Claims: claims}, nil
+}
+
+// verifyIDToken 校验 ID Token 的签名、issuer、audience、有效期和 nonce
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, doc *oidcDiscovery, rawToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, doc, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithLeeway(oidcLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: invalid id_token: %w", err)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("oidc: id_token has no expiration")
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("oidc: id_token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// publicKey 按 kid 查找签名公钥，找不到时重新获取 JWKS 以支持 issuer 轮换密钥
+func (p *OIDCProvider) publicKey(ctx context.Context, doc *oidcDiscovery, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	keys, err := fetchJWKS(ctx, doc.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	// 没有 kid 时只接受唯一的密钥
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("oidc: signing key %q not found", kid)
+}
+
+// fetchJWKS 获取 JWKS 中用于签名的 RSA 公钥，以 kid 为键
+func fetchJWKS(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// FetchProfile 从 ID Token 声明中读取帐户信息，issuer 提供 userinfo 端点时用其补充，userinfo 的 sub 必须一致
+func (p *OIDCProvider) FetchProfile(ctx context.Context, token *Token) (*Profile, error) {
+	profile := profileFromClaims(token.Claims)
+	profile.ID = token.Subject
+
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if doc.UserinfoEndpoint == "" || token.AccessToken == "" {
+		return profile, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, doc.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	var info map[string]interface{}
+	if err := doJSON(req, &info); err != nil {
+		return nil, err
+	}
+	if sub, _ := info["sub"].(string); sub != token.Subject {
+		return nil, errors.New("oidc: userinfo subject mismatch")
+	}
+
+	extra := profileFromClaims(info)
+	if extra.Name != "" {
+		profile.Name = extra.Name
+	}
+	if extra.Email != "" {
+		profile.Email = extra.Email
+	}
+	if extra.AvatarURL != "" {
+		profile.AvatarURL = extra.AvatarURL
+	}
+	return profile, nil
+}
+
+// profileFromClaims 读取标准声明中的用户名、邮箱和头像
+func profileFromClaims(claims map[string]interface{}) *Profile {
+	str := func(key string) string {
+		value, _ := claims[key].(string)
+		return value
+	}
+	name := str("preferred_username")
+	if name == "" {
+		name = str("name")
+	}
+	return &Profile{
+		Name:      name,
+		Email:     str("email"),
+		AvatarURL: str("picture"),
+	}
+}
@@ -0,0 +1,332 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "hyper-pen"
+	testClientSecret = "secret"
+	testRedirectURI  = "http://localhost/api/auth/oidc/callback"
+	testSubject      = "user-42"
+)
+
+// mockIssuer 本地 OIDC issuer，提供 discovery、JWKS、授权、令牌和 userinfo 端点
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]url.Values // 授权码 -> 授权请求参数
+
+	// 以下字段用于构造异常的响应
+	discoveryIssuer string                       // discovery 文档中的 issuer，为空时使用服务器地址
+	jwksKid         string                       // JWKS 中公钥的 kid
+	signKid         string                       // ID Token 头部的 kid
+	claims          func(jwt.MapClaims)          // 签发前修改 ID Token 声明
+	userinfo        func(map[string]interface{}) // 返回前修改 userinfo
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, codes: make(map[string]url.Values), jwksKid: "key-1", signKid: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := m.discoveryIssuer
+		if issuer == "" {
+			issuer = m.URL
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"userinfo_endpoint":      m.URL + "/userinfo",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": m.jwksKid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		info := map[string]interface{}{"sub": testSubject, "email": "alice@example.com", "picture": "https://example.com/a.png"}
+		if m.userinfo != nil {
+			m.userinfo(info)
+		}
+		json.NewEncoder(w).Encode(info)
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize 模拟用户在 issuer 上完成授权，返回回调中的授权码
+func (m *mockIssuer) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := u.Query()
+	code := "code-" + params.Get("state")
+	m.mu.Lock()
+	m.codes[code] = params
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	m.mu.Lock()
+	params, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || params.Get("code_challenge_method") != "S256" ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != params.Get("code_challenge") ||
+		r.PostForm.Get("client_id") != testClientID || r.PostForm.Get("client_secret") != testClientSecret {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                m.URL,
+		"aud":                testClientID,
+		"sub":                testSubject,
+		"nonce":              params.Get("nonce"),
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"preferred_username": "alice",
+	}
+	if m.claims != nil {
+		m.claims(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.signKid
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "access-token", "id_token": idToken, "token_type": "Bearer"})
+}
+
+func TestOIDCProviderLogin(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := NewOIDCProvider("oidc", issuer.URL, testClientID, testClientSecret, testRedirectURI, []string{"openid", "profile", "email"})
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthURL(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	params := u.Query()
+	for key, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURI,
+		"scope":                 "openid profile email",
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge(),
+		"code_challenge_method": "S256",
+	} {
+		if got := params.Get(key); got != want {
+			t.Errorf("auth url %s = %q, want %q", key, got, want)
+		}
+	}
+
+	ctx := context.Background()
+	token, err := provider.Exchange(ctx, issuer.authorize(t, authURL), req)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if token.Subject != testSubject {
+		t.Errorf("subject = %q, want %q", token.Subject, testSubject)
+	}
+
+	profile, err := provider.FetchProfile(ctx, token)
+	if err != nil {
+		t.Fatalf("FetchProfile: %v", err)
+	}
+	want := Profile{ID: testSubject, Name: "alice", Email: "alice@example.com", AvatarURL: "https://example.com/a.png"}
+	if *profile != want {
+		t.Errorf("profile = %+v, want %+v", *profile, want)
+	}
+}
+
+func TestOIDCProviderRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(m *mockIssuer)
+		request func(req *AuthRequest)
+		wantErr string
+	}{
+		{
+			name:    "bad nonce",
+			setup:   func(m *mockIssuer) { m.claims = func(c jwt.MapClaims) { c["nonce"] = "other" } },
+			wantErr: "nonce mismatch",
+		},
+		{
+			name:    "missing nonce",
+			setup:   func(m *mockIssuer) { m.claims = func(c jwt.MapClaims) { delete(c, "nonce") } },
+			wantErr: "nonce mismatch",
+		},
+		{
+			name:    "wrong audience",
+			setup:   func(m *mockIssuer) { m.claims = func(c jwt.MapClaims) { c["aud"] = "another-client" } },
+			wantErr: "invalid id_token",
+		},
+		{
+			name:    "wrong issuer in id_token",
+			setup:   func(m *mockIssuer) { m.claims = func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" } },
+			wantErr: "invalid id_token",
+		},
+		{
+			name:    "expired id_token",
+			setup:   func(m *mockIssuer) { m.claims = func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() } },
+			wantErr: "invalid id_token",
+		},
+		{
+			name:    "missing expiration",
+			setup:   func(m *mockIssuer) { m.claims = func(c jwt.MapClaims) { delete(c, "exp") } },
+			wantErr: "no expiration",
+		},
+		{
+			name:    "unknown kid",
+			setup:   func(m *mockIssuer) { m.signKid = "key-2" },
+			wantErr: `signing key "key-2" not found`,
+		},
+		{
+			name:    "pkce verifier mismatch",
+			request: func(req *AuthRequest) { req.CodeVerifier = "tampered-verifier" },
+			wantErr: "status 400",
+		},
+		{
+			name:    "discovery issuer mismatch",
+			setup:   func(m *mockIssuer) { m.discoveryIssuer = "https://evil.example.com" },
+			wantErr: "issuer mismatch",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newMockIssuer(t)
+			if tt.setup != nil {
+				tt.setup(issuer)
+			}
+			provider := NewOIDCProvider("oidc", issuer.URL, testClientID, testClientSecret, testRedirectURI, []string{"openid"})
+
+			req, err := NewAuthRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			authURL, err := provider.AuthURL(req)
+			if err == nil {
+				code := issuer.authorize(t, authURL)
+				if tt.request != nil {
+					tt.request(&req)
+				}
+				_, err = provider.Exchange(context.Background(), code, req)
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCProviderUserinfoSubjectMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.userinfo = func(info map[string]interface{}) { info["sub"] = "someone-else" }
+	provider := NewOIDCProvider("oidc", issuer.URL, testClientID, testClientSecret, testRedirectURI, []string{"openid"})
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthURL(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := provider.Exchange(context.Background(), issuer.authorize(t, authURL), req)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := provider.FetchProfile(context.Background(), token); err == nil || !strings.Contains(err.Error(), "subject mismatch") {
+		t.Errorf("FetchProfile error = %v, want subject mismatch", err)
+	}
+}
+
+func TestOIDCProviderKeyRotation(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := NewOIDCProvider("oidc", issuer.URL, testClientID, testClientSecret, testRedirectURI, []string{"openid"})
+
+	login := func() error {
+		req, err := NewAuthRequest()
+		if err != nil {
+			return err
+		}
+		authURL, err := provider.AuthURL(req)
+		if err != nil {
+			return err
+		}
+		_, err = provider.Exchange(context.Background(), issuer.authorize(t, authURL), req)
+		return err
+	}
+	if err := login(); err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+
+	// issuer 换用新密钥和新 kid 后，缓存中找不到 kid 时重新获取 JWKS
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.key = key
+	issuer.jwksKid = "key-2"
+	issuer.signKid = "key-2"
+	if err := login(); err != nil {
+		t.Fatalf("login after key rotation: %v", err)
+	}
+}
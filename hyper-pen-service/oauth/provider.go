@@ -0,0 +1,114 @@
+// Package oauth 第三方身份提供方。每个提供方负责构建授权地址、用授权码换取令牌和获取帐户信息，
+// 登录会话、state 的保存和用户的查找或创建由调用方处理。
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// httpTimeout 访问第三方接口的超时时间
+const httpTimeout = 10 * time.Second
+
+// httpClient 所有提供方共用的 HTTP 客户端，避免第三方接口无响应时请求一直挂起
+var httpClient = &http.Client{Timeout: httpTimeout}
+
+// AuthRequest 一次授权请求的参数。调用方以 State 为键保存在登录会话中，回调时原样传回，
+// 提供方用 Nonce 和 CodeVerifier 校验回调结果
+type AuthRequest struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// NewAuthRequest 生成随机的 state、nonce 和 PKCE code verifier
+func NewAuthRequest() (AuthRequest, error) {
+	var req AuthRequest
+	var err error
+	if req.State, err = RandomString(16); err != nil {
+		return req, err
+	}
+	if req.Nonce, err = RandomString(16); err != nil {
+		return req, err
+	}
+	if req.CodeVerifier, err = RandomString(32); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// CodeChallenge PKCE S256 code challenge
+func (r AuthRequest) CodeChallenge() string {
+	sum := sha256.Sum256([]byte(r.CodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Token 用授权码换取的令牌
+type Token struct {
+	AccessToken string
+	// Subject 提供方在换取令牌时已经确定的帐户ID，例如微信的 openid 和 OIDC ID Token 的 sub
+	Subject string
+	// Claims 已校验的 OIDC ID Token 声明
+	Claims map[string]interface{}
+}
+
+// Profile 第三方帐户信息
+type Profile struct {
+	ID        string
+	Name      string
+	Email     string
+	AvatarURL string
+}
+
+// IdentityProvider 第三方身份提供方
+type IdentityProvider interface {
+	// Name 提供方名称，用于路由和保存绑定关系
+	Name() string
+	// AuthURL 构建跳转到提供方的授权地址
+	AuthURL(req AuthRequest) (string, error)
+	// Exchange 用授权码换取令牌，req 为发起授权时的参数
+	Exchange(ctx context.Context, code string, req AuthRequest) (*Token, error)
+	// FetchProfile 获取帐户信息
+	FetchProfile(ctx context.Context, token *Token) (*Profile, error)
+}
+
+// RandomString 生成 n 字节随机数的 URL 安全编码
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// doJSON 发送请求并把 JSON 响应解码到 v，非 2xx 响应返回错误
+func doJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("oauth: %s %s: status %d: %s", req.Method, req.URL.Redacted(), resp.StatusCode, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// getJSON 以 GET 请求 JSON 接口
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(req, v)
+}
@@ -0,0 +1,90 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+const (
+	wechatAuthorizeURL = "https://open.weixin.qq.com/connect/qrconnect"
+	wechatTokenURL     = "https://api.weixin.qq.com/sns/oauth2/access_token"
+	wechatUserURL      = "https://api.weixin.qq.com/sns/userinfo"
+)
+
+// WechatProvider 微信开放平台网站应用扫码登录。微信不支持 PKCE，只校验 state
+type WechatProvider struct {
+	AppID       string
+	AppSecret   string
+	RedirectURI string
+}
+
+// NewWechatProvider 创建微信身份提供方
+func NewWechatProvider(appID, appSecret, redirectURI string) *WechatProvider {
+	return &WechatProvider{AppID: appID, AppSecret: appSecret, RedirectURI: redirectURI}
+}
+
+func (p *WechatProvider) Name() string {
+	return "wechat"
+}
+
+// AuthURL 构建微信扫码授权地址
+func (p *WechatProvider) AuthURL(req AuthRequest) (string, error) {
+	params := url.Values{}
+	params.Set("appid", p.AppID)
+	params.Set("redirect_uri", p.RedirectURI)
+	params.Set("response_type", "code")
+	params.Set("scope", "snsapi_login")
+	params.Set("state", req.State)
+	return wechatAuthorizeURL + "?" + params.Encode() + "#wechat_redirect", nil
+}
+
+// Exchange 用授权码换取微信访问令牌和 openid
+func (p *WechatProvider) Exchange(ctx context.Context, code string, req AuthRequest) (*Token, error) {
+	params := url.Values{}
+	params.Set("appid", p.AppID)
+	params.Set("secret", p.AppSecret)
+	params.Set("code", code)
+	params.Set("grant_type", "authorization_code")
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		OpenID      string `json:"openid"`
+		ErrCode     int    `json:"errcode"`
+		ErrMsg      string `json:"errmsg"`
+	}
+	if err := getJSON(ctx, wechatTokenURL+"?"+params.Encode(), &result); err != nil {
+		return nil, err
+	}
+	// 授权码无效时微信返回 errcode，没有 openid
+	if result.ErrCode != 0 || result.OpenID == "" {
+		return nil, fmt.Errorf("wechat: %d %s", result.ErrCode, result.ErrMsg)
+	}
+	return &Token{AccessToken: result.AccessToken, Subject: result.OpenID}, nil
+}
+
+// FetchProfile 获取微信用户信息
+func (p *WechatProvider) FetchProfile(ctx context.Context, token *Token) (*Profile, error) {
+	params := url.Values{}
+	params.Set("access_token", token.AccessToken)
+	params.Set("openid", token.Subject)
+	params.Set("lang", "zh_CN")
+
+	var user struct {
+		Nickname   string `json:"nickname"`
+		HeadImgURL string `json:"headimgurl"`
+		ErrCode    int    `json:"errcode"`
+		ErrMsg     string `json:"errmsg"`
+	}
+	if err := getJSON(ctx, wechatUserURL+"?"+params.Encode(), &user); err != nil {
+		return nil, err
+	}
+	if user.ErrCode != 0 {
+		return nil, fmt.Errorf("wechat: %d %s", user.ErrCode, user.ErrMsg)
+	}
+	return &Profile{
+		ID:        token.Subject,
+		Name:      user.Nickname,
+		AvatarURL: user.HeadImgURL,
+	}, nil
+}
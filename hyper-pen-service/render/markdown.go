@@ -0,0 +1,130 @@
+// Package render 在服务端把笔记的 Markdown 渲染为经过清理的 HTML，客户端无需自行处理 XSS。
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+)
+
+type cacheEntry struct {
+	version int
+	html    string
+}
+
+// Renderer Markdown 渲染器，支持 GFM 表格、任务列表、脚注和标题锚点。
+// 渲染结果按笔记ID缓存，只保留最新版本，笔记更新后旧版本的结果自然失效
+type Renderer struct {
+	md     goldmark.Markdown
+	policy *bluemonday.Policy
+
+	mu        sync.Mutex
+	cache     map[string]cacheEntry
+	cacheSize int
+}
+
+// NewRenderer 创建渲染器，cacheSize 小于等于 0 时不缓存
+func NewRenderer(cacheSize int) *Renderer {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM, extension.Footnote),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	)
+	return &Renderer{
+		md:        md,
+		policy:    newPolicy(),
+		cache:     make(map[string]cacheEntry),
+		cacheSize: cacheSize,
+	}
+}
+
+// newPolicy 在 UGC 策略的基础上允许中文标题锚点、脚注和任务列表的复选框
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// UGC 策略允许包含 ASCII 字母、数字或 :-_. 的 id，这里只补充完全由非 ASCII 字符组成的 id，
+	// 两条规则同时匹配时同一个属性会输出两次
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[^\x00-\x7F]+$`)).Globally()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^footnote(-[a-z]+)*$`)).Globally()
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(noteref|endnotes|backlink)$`)).Globally()
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}
+
+// headingIDs 生成标题锚点，保留中文等非 ASCII 字母，重复的锚点加上数字后缀
+type headingIDs struct {
+	used map[string]bool
+}
+
+func (s *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	var b strings.Builder
+	for _, r := range strings.ToLower(string(value)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_' || r == '-':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune('-')
+		}
+	}
+	id := b.String()
+	if id == "" {
+		id = "heading"
+	}
+	unique := id
+	for i := 1; s.used[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", id, i)
+	}
+	s.used[unique] = true
+	return []byte(unique)
+}
+
+func (s *headingIDs) Put(value []byte) {
+	s.used[string(value)] = true
+}
+
+// Render 渲染笔记正文，相同笔记ID和版本的结果直接从缓存返回
+func (r *Renderer) Render(noteID string, version int, content string) (string, error) {
+	r.mu.Lock()
+	entry, ok := r.cache[noteID]
+	r.mu.Unlock()
+	if ok && entry.version == version {
+		return entry.html, nil
+	}
+
+	html, err := r.RenderString(content)
+	if err != nil {
+		return "", err
+	}
+
+	if r.cacheSize > 0 {
+		r.mu.Lock()
+		if _, exists := r.cache[noteID]; !exists && len(r.cache) >= r.cacheSize {
+			// 缓存已满时随机淘汰一项
+			for key := range r.cache {
+				delete(r.cache, key)
+				break
+			}
+		}
+		r.cache[noteID] = cacheEntry{version: version, html: html}
+		r.mu.Unlock()
+	}
+	return html, nil
+}
+
+// RenderString 渲染任意 Markdown，不使用缓存
+func (r *Renderer) RenderString(content string) (string, error) {
+	var buf bytes.Buffer
+	ids := &headingIDs{used: make(map[string]bool)}
+	if err := r.md.Convert([]byte(content), &buf, parser.WithContext(parser.NewContext(parser.WithIDs(ids)))); err != nil {
+		return "", err
+	}
+	return r.policy.Sanitize(buf.String()), nil
+}
@@ -0,0 +1,184 @@
+// Package search 基于 SQLite FTS5 的笔记全文索引。
+//
+// 需要使用 -tags sqlite_fts5 编译，否则 Setup 返回错误，调用方应退回到 LIKE 查询。
+package search
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// 索引使用 trigram 分词器，支持中文等不以空格分词的文本，代价是少于 3 个字符的词无法使用索引
+const (
+	ftsTable = "notes_fts"
+	idsTable = "notes_fts_ids"
+	// minTermLength trigram 分词器可匹配的最短词长
+	minTermLength = 3
+	// titleWeight 标题在 bm25 排序中的权重，正文为 1
+	titleWeight = "10.0"
+
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+var available bool
+
+var setupStatements = []string{
+	// notes 的主键是字符串，使用映射表为每篇笔记分配稳定的整数 rowid
+	`CREATE TABLE IF NOT EXISTS ` + idsTable + ` (id INTEGER PRIMARY KEY, note_id TEXT NOT NULL UNIQUE)`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS ` + ftsTable + ` USING fts5(title, content, tokenize = 'trigram')`,
+	`CREATE TRIGGER IF NOT EXISTS notes_fts_insert AFTER INSERT ON notes WHEN new.deleted_at IS NULL BEGIN
+		INSERT INTO ` + idsTable + ` (note_id) VALUES (new.id);
+		INSERT INTO ` + ftsTable + ` (rowid, title, content)
+			SELECT id, new.title, new.content FROM ` + idsTable + ` WHERE note_id = new.id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS notes_fts_delete AFTER DELETE ON notes BEGIN
+		DELETE FROM ` + ftsTable + ` WHERE rowid = (SELECT id FROM ` + idsTable + ` WHERE note_id = old.id);
+		DELETE FROM ` + idsTable + ` WHERE note_id = old.id;
+	END`,
+	// 软删除（移入回收站）时从索引中移除，恢复时重新加入
+	`CREATE TRIGGER IF NOT EXISTS notes_fts_update AFTER UPDATE OF title, content, deleted_at ON notes BEGIN
+		DELETE FROM ` + ftsTable + ` WHERE rowid = (SELECT id FROM ` + idsTable + ` WHERE note_id = old.id);
+		DELETE FROM ` + idsTable + ` WHERE note_id = old.id;
+		INSERT INTO ` + idsTable + ` (note_id) SELECT new.id WHERE new.deleted_at IS NULL;
+		INSERT INTO ` + ftsTable + ` (rowid, title, content)
+			SELECT id, new.title, new.content FROM ` + idsTable + ` WHERE note_id = new.id;
+	END`,
+}
+
+// triggers 同步索引的触发器
+var triggers = []string{"notes_fts_insert", "notes_fts_update", "notes_fts_delete"}
+
+// Setup 创建全文索引表和同步触发器，首次创建时会回填已有笔记。
+// 创建失败（未启用 FTS5）时删除之前启用 FTS5 的版本留下的触发器，否则写入笔记时会因找不到 fts5 模块而失败；
+// 之后重新启用时触发器缺失，会重建索引补上期间的修改
+func Setup(db *gorm.DB) error {
+	rebuildNeeded := !db.Migrator().HasTable(ftsTable)
+	for _, name := range triggers {
+		var count int64
+		if err := db.Raw(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?`, name).Scan(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			rebuildNeeded = true
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range setupStatements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		// 索引表已存在时 CREATE VIRTUAL TABLE IF NOT EXISTS 不会检查 fts5 模块，需要实际查询一次
+		if err := tx.Exec(`SELECT rowid FROM ` + ftsTable + ` LIMIT 0`).Error; err != nil {
+			return err
+		}
+		if rebuildNeeded {
+			_, err := rebuild(tx)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		available = false
+		for _, name := range triggers {
+			if dropErr := db.Exec(`DROP TRIGGER IF EXISTS ` + name).Error; dropErr != nil {
+				return fmt.Errorf("%w; drop trigger %s: %v", err, name, dropErr)
+			}
+		}
+		return err
+	}
+
+	available = true
+	return nil
+}
+
+// Available 全文索引是否可用
+func Available() bool {
+	return available
+}
+
+// Rebuild 清空并根据 notes 表重建索引，返回索引的笔记数量
+func Rebuild(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		count, err = rebuild(tx)
+		return err
+	})
+	return count, err
+}
+
+func rebuild(tx *gorm.DB) (int64, error) {
+	if err := tx.Exec(`DELETE FROM ` + ftsTable).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Exec(`DELETE FROM ` + idsTable).Error; err != nil {
+		return 0, err
+	}
+
+	result := tx.Exec(`INSERT INTO ` + idsTable + ` (note_id) SELECT id FROM notes WHERE deleted_at IS NULL`)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if err := tx.Exec(`INSERT INTO ` + ftsTable + ` (rowid, title, content)
+		SELECT m.id, n.title, n.content FROM ` + idsTable + ` m JOIN notes n ON n.id = m.note_id`).Error; err != nil {
+		return 0, err
+	}
+	return result.RowsAffected, nil
+}
+
+// MatchExpression 将关键词和短语转换为 FTS5 查询，所有词均需匹配；
+// 太短而无法走索引的词通过 shortTerms 返回，由调用方使用 LIKE 过滤
+func MatchExpression(terms []string) (match string, shortTerms []string) {
+	var phrases []string
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < minTermLength {
+			shortTerms = append(shortTerms, term)
+			continue
+		}
+		phrases = append(phrases, Quote(term))
+	}
+	return strings.Join(phrases, " AND "), shortTerms
+}
+
+// Quote 将文本转换为 FTS5 短语
+func Quote(term string) string {
+	return `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+}
+
+// Join 在笔记查询上关联全文索引并按 match 过滤
+func Join(db *gorm.DB, match string) *gorm.DB {
+	return db.Joins("JOIN "+idsTable+" ON "+idsTable+".note_id = notes.id").
+		Joins("JOIN "+ftsTable+" ON "+ftsTable+".rowid = "+idsTable+".id").
+		Where(ftsTable+" MATCH ?", match)
+}
+
+// HitColumns 查询命中结果时选择的列，需与 Join 一起使用
+const HitColumns = "notes.id AS note_id, " +
+	"bm25(" + ftsTable + ", " + titleWeight + ", 1.0) AS rank, " +
+	"highlight(" + ftsTable + ", 0, '" + highlightStart + "', '" + highlightEnd + "') AS title_highlight, " +
+	"snippet(" + ftsTable + ", 1, '" + highlightStart + "', '" + highlightEnd + "', '…', 24) AS snippet"
+
+// RankColumn 相关度排序字段，bm25 越小越相关
+const RankColumn = "rank"
+
+// Hit 一条命中结果
+type Hit struct {
+	NoteID         string
+	Rank           float64
+	TitleHighlight string
+	Snippet        string
+}
+
+// HighlightHTML 转义命中片段并用 <mark> 标记匹配的文本
+func HighlightHTML(fragment string) string {
+	escaped := html.EscapeString(fragment)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, highlightEnd, "</mark>")
+}
@@ -0,0 +1,287 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 子句类型
+const (
+	KindTerm     = "term"
+	KindPhrase   = "phrase"
+	KindTag      = "tag"
+	KindCategory = "category"
+	KindUpdated  = "updated"
+	KindCreated  = "created"
+)
+
+// 支持的 field:value 字段
+var queryFields = map[string]string{
+	"tag":      KindTag,
+	"category": KindCategory,
+	"updated":  KindUpdated,
+	"created":  KindCreated,
+}
+
+// Query 解析后的搜索查询，各子句之间为“与”关系
+type Query struct {
+	Raw     string   `json:"raw"`
+	Clauses []Clause `json:"clauses"`
+}
+
+// Clause 查询中的一个条件。Start 和 End 为该条件在原始查询中的字符位置（从 0 开始，不含 End）
+type Clause struct {
+	Kind    string     `json:"kind"`
+	Value   string     `json:"value"`
+	Negated bool       `json:"negated,omitempty"`
+	Op      string     `json:"op,omitempty"`   // 日期比较符：>、>=、<、<=、=
+	From    *time.Time `json:"from,omitempty"` // 日期条件对应的时间范围 [From, To)
+	To      *time.Time `json:"to,omitempty"`
+	Start   int        `json:"start"`
+	End     int        `json:"end"`
+}
+
+// ParseError 查询语法错误，Position 为出错的字符位置
+type ParseError struct {
+	Position int    `json:"position"`
+	Message  string `json:"message"`
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Position, e.Message)
+}
+
+// ParseQuery 解析搜索查询，例如：
+//
+//	tag:work category:"Project X" updated:>2026-01-01 "exact phrase" -draft
+//
+// 普通词和带引号的短语匹配标题或正文；tag:、category: 按名称过滤（不区分大小写）；
+// updated:、created: 接受 YYYY-MM-DD 或 RFC3339 时间，可带比较符；前缀 - 表示排除
+func ParseQuery(raw string) (*Query, error) {
+	p := &queryParser{input: []rune(raw)}
+	query := &Query{Raw: raw, Clauses: []Clause{}}
+
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return query, nil
+		}
+		clause, err := p.clause()
+		if err != nil {
+			return nil, err
+		}
+		query.Clauses = append(query.Clauses, *clause)
+	}
+}
+
+// TextTerms 需要匹配的普通词和短语
+func (q *Query) TextTerms() []Clause {
+	var terms []Clause
+	for _, c := range q.Clauses {
+		if !c.Negated && (c.Kind == KindTerm || c.Kind == KindPhrase) {
+			terms = append(terms, c)
+		}
+	}
+	return terms
+}
+
+type queryParser struct {
+	input []rune
+	pos   int
+}
+
+func (p *queryParser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *queryParser) peek() rune {
+	return p.input[p.pos]
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+func (p *queryParser) errorf(pos int, format string, args ...interface{}) error {
+	return &ParseError{Position: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// clause 解析一个以空白分隔的条件
+func (p *queryParser) clause() (*Clause, error) {
+	clause := &Clause{Start: p.pos}
+
+	if p.peek() == '-' {
+		clause.Negated = true
+		p.pos++
+		if p.eof() || unicode.IsSpace(p.peek()) {
+			return nil, p.errorf(p.pos, "expected a term after '-'")
+		}
+	}
+
+	if p.peek() == '"' {
+		value, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(value) == "" {
+			return nil, p.errorf(clause.Start, "empty phrase")
+		}
+		clause.Kind, clause.Value = KindPhrase, value
+		clause.End = p.pos
+		return clause, p.expectSeparator()
+	}
+
+	if kind, ok := p.field(); ok {
+		return p.fieldValue(clause, kind)
+	}
+
+	clause.Kind, clause.Value = KindTerm, p.word()
+	clause.End = p.pos
+	return clause, nil
+}
+
+// field 识别 name: 前缀，未知字段按普通词处理
+func (p *queryParser) field() (string, bool) {
+	end := p.pos
+	for end < len(p.input) && unicode.IsLetter(p.input[end]) {
+		end++
+	}
+	if end == p.pos || end >= len(p.input) || p.input[end] != ':' {
+		return "", false
+	}
+	kind, ok := queryFields[strings.ToLower(string(p.input[p.pos:end]))]
+	if !ok {
+		return "", false
+	}
+	p.pos = end + 1
+	return kind, true
+}
+
+// fieldValue 解析字段的值
+func (p *queryParser) fieldValue(clause *Clause, kind string) (*Clause, error) {
+	clause.Kind = kind
+	valueStart := p.pos
+	if p.eof() || unicode.IsSpace(p.peek()) {
+		return nil, p.errorf(valueStart, "missing value for %s:", kind)
+	}
+
+	var value string
+	if p.peek() == '"' {
+		var err error
+		if value, err = p.quoted(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSeparator(); err != nil {
+			return nil, err
+		}
+	} else {
+		value = p.word()
+	}
+	clause.End = p.pos
+
+	switch kind {
+	case KindUpdated, KindCreated:
+		if clause.Negated {
+			return nil, p.errorf(clause.Start, "%s: cannot be negated, use the opposite comparison instead", kind)
+		}
+		if err := parseDateFilter(clause, value, valueStart); err != nil {
+			return nil, err
+		}
+	default:
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil, p.errorf(valueStart, "missing value for %s:", kind)
+		}
+		clause.Value = value
+	}
+	return clause, nil
+}
+
+// quoted 解析双引号中的文本，支持 \" 和 \\ 转义
+func (p *queryParser) quoted() (string, error) {
+	open := p.pos
+	p.pos++
+
+	var b strings.Builder
+	for !p.eof() {
+		r := p.peek()
+		p.pos++
+		switch r {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if !p.eof() && (p.peek() == '"' || p.peek() == '\\') {
+				r = p.peek()
+				p.pos++
+			}
+		}
+		b.WriteRune(r)
+	}
+	return "", p.errorf(open, "unterminated quote")
+}
+
+// word 读取到下一个空白为止
+func (p *queryParser) word() string {
+	start := p.pos
+	for !p.eof() && !unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+	return string(p.input[start:p.pos])
+}
+
+// expectSeparator 引号结束后必须是空白或查询结尾
+func (p *queryParser) expectSeparator() error {
+	if !p.eof() && !unicode.IsSpace(p.peek()) {
+		return p.errorf(p.pos, "expected a space after closing quote")
+	}
+	return nil
+}
+
+// parseDateFilter 解析 [比较符]日期，仅有日期时比较整天
+func parseDateFilter(clause *Clause, value string, pos int) error {
+	op := "="
+	for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, candidate) {
+			op = candidate
+			value = value[len(candidate):]
+			pos += len(candidate)
+			break
+		}
+	}
+
+	from, to, err := parseDateValue(value)
+	if err != nil {
+		return &ParseError{Position: pos, Message: fmt.Sprintf("invalid date %q, expected YYYY-MM-DD or RFC3339", value)}
+	}
+
+	clause.Op, clause.Value = op, value
+	switch op {
+	case ">":
+		clause.From = &to
+	case ">=":
+		clause.From = &from
+	case "<":
+		clause.To = &from
+	case "<=":
+		clause.To = &to
+	default:
+		clause.From, clause.To = &from, &to
+	}
+	return nil
+}
+
+// parseDateValue 返回日期所覆盖的时间范围 [from, to)；RFC3339 时间视为精确到纳秒的一个时刻
+func parseDateValue(value string) (from, to time.Time, err error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, t.Add(time.Nanosecond), nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return day, day.AddDate(0, 0, 1), nil
+}
@@ -0,0 +1,171 @@
+package search
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []Clause
+	}{
+		{query: "", want: []Clause{}},
+		{query: "   ", want: []Clause{}},
+		{
+			query: "hello world",
+			want: []Clause{
+				{Kind: KindTerm, Value: "hello", Start: 0, End: 5},
+				{Kind: KindTerm, Value: "world", Start: 6, End: 11},
+			},
+		},
+		{
+			query: `"exact phrase" -draft`,
+			want: []Clause{
+				{Kind: KindPhrase, Value: "exact phrase", Start: 0, End: 14},
+				{Kind: KindTerm, Value: "draft", Negated: true, Start: 15, End: 21},
+			},
+		},
+		{
+			query: `tag:work category:"Project X" -tag:old`,
+			want: []Clause{
+				{Kind: KindTag, Value: "work", Start: 0, End: 8},
+				{Kind: KindCategory, Value: "Project X", Start: 9, End: 29},
+				{Kind: KindTag, Value: "old", Negated: true, Start: 30, End: 38},
+			},
+		},
+		{
+			query: "TAG:Go unknown:field",
+			want: []Clause{
+				{Kind: KindTag, Value: "Go", Start: 0, End: 6},
+				{Kind: KindTerm, Value: "unknown:field", Start: 7, End: 20},
+			},
+		},
+		{
+			query: `"say \"hi\" \\ bye"`,
+			want: []Clause{
+				{Kind: KindPhrase, Value: `say "hi" \ bye`, Start: 0, End: 19},
+			},
+		},
+		{
+			// 位置按字符而不是字节计算
+			query: "标签 tag:工作",
+			want: []Clause{
+				{Kind: KindTerm, Value: "标签", Start: 0, End: 2},
+				{Kind: KindTag, Value: "工作", Start: 3, End: 9},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+			if q.Raw != tt.query {
+				t.Errorf("Raw = %q, want %q", q.Raw, tt.query)
+			}
+			if len(q.Clauses) != len(tt.want) {
+				t.Fatalf("got %d clauses %+v, want %d", len(q.Clauses), q.Clauses, len(tt.want))
+			}
+			for i, got := range q.Clauses {
+				want := tt.want[i]
+				if got.Kind != want.Kind || got.Value != want.Value || got.Negated != want.Negated ||
+					got.Start != want.Start || got.End != want.End {
+					t.Errorf("clause %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseQueryDates(t *testing.T) {
+	day := time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local)
+	next := day.AddDate(0, 0, 1)
+	instant := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		query string
+		op    string
+		from  *time.Time
+		to    *time.Time
+	}{
+		{query: "updated:2026-01-02", op: "=", from: &day, to: &next},
+		{query: "updated:=2026-01-02", op: "=", from: &day, to: &next},
+		{query: "updated:>2026-01-02", op: ">", from: &next},
+		{query: "updated:>=2026-01-02", op: ">=", from: &day},
+		{query: "created:<2026-01-02", op: "<", to: &day},
+		{query: "created:<=2026-01-02", op: "<=", to: &next},
+		{query: "created:>=2026-01-02T03:04:05Z", op: ">=", from: &instant},
+	}
+
+	sameTime := func(a, b *time.Time) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.Equal(*b)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+			c := q.Clauses[0]
+			if c.Op != tt.op || !sameTime(c.From, tt.from) || !sameTime(c.To, tt.to) {
+				t.Errorf("clause = op %q from %v to %v, want op %q from %v to %v", c.Op, c.From, c.To, tt.op, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		position int
+		message  string
+	}{
+		{query: "-", position: 1, message: "expected a term after '-'"},
+		{query: "a - b", position: 3, message: "expected a term after '-'"},
+		{query: `"unterminated`, position: 0, message: "unterminated quote"},
+		{query: `word "open`, position: 5, message: "unterminated quote"},
+		{query: `"  "`, position: 0, message: "empty phrase"},
+		{query: `"phrase"next`, position: 8, message: "expected a space after closing quote"},
+		{query: "tag:", position: 4, message: "missing value for tag:"},
+		{query: "x category: y", position: 11, message: "missing value for category:"},
+		{query: `tag:" "`, position: 4, message: "missing value for tag:"},
+		{query: `tag:"open`, position: 4, message: "unterminated quote"},
+		{query: `tag:"a"b`, position: 7, message: "expected a space after closing quote"},
+		{query: "-updated:2026-01-01", position: 0, message: "updated: cannot be negated, use the opposite comparison instead"},
+		{query: "updated:yesterday", position: 8, message: `invalid date "yesterday", expected YYYY-MM-DD or RFC3339`},
+		{query: "a created:>=2026-13-01", position: 12, message: `invalid date "2026-13-01", expected YYYY-MM-DD or RFC3339`},
+		{query: "笔记 updated:<x", position: 12, message: `invalid date "x", expected YYYY-MM-DD or RFC3339`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseQuery(tt.query)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseQuery error = %v, want *ParseError", err)
+			}
+			if parseErr.Position != tt.position || parseErr.Message != tt.message {
+				t.Errorf("error = %d %q, want %d %q", parseErr.Position, parseErr.Message, tt.position, tt.message)
+			}
+		})
+	}
+}
+
+func TestQueryTextTerms(t *testing.T) {
+	q, err := ParseQuery(`alpha -beta "gamma delta" tag:x -"epsilon"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	terms := q.TextTerms()
+	if len(terms) != 2 || terms[0].Value != "alpha" || terms[1].Value != "gamma delta" {
+		t.Errorf("TextTerms = %+v, want alpha and \"gamma delta\"", terms)
+	}
+}
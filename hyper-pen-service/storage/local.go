@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalStorage 本地文件系统存储，文件保存在 root/ab/cd/<sha256>
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage 创建本地文件系统存储，root 不存在时自动创建
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{root: root}, nil
+}
+
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.root, key[:2], key[2:4], key)
+}
+
+// Put 先写入临时文件并校验内容哈希，再原子地移动到目标位置
+func (s *LocalStorage) Put(key string, r io.Reader) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	target := s.path(key)
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != key {
+		return errors.New("storage: content does not match key")
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// Open 打开文件
+func (s *LocalStorage) Open(key string) (io.ReadSeekCloser, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Exists 文件是否存在
+func (s *LocalStorage) Exists(key string) (bool, error) {
+	if !ValidKey(key) {
+		return false, ErrInvalidKey
+	}
+	_, err := os.Stat(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete 删除文件
+func (s *LocalStorage) Delete(key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
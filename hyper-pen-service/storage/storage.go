@@ -0,0 +1,45 @@
+// Package storage 附件文件的存储。文件按内容的 sha256 寻址，相同内容只保存一份。
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// ErrNotFound 文件不存在
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrInvalidKey 键不是合法的 sha256 十六进制字符串
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+var keyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Storage 附件存储后端，键为文件内容的 sha256 十六进制字符串
+type Storage interface {
+	// Put 保存内容，键已存在时直接返回
+	Put(key string, r io.Reader) error
+	// Open 打开文件，不存在时返回 ErrNotFound
+	Open(key string) (io.ReadSeekCloser, error)
+	// Exists 文件是否存在
+	Exists(key string) (bool, error)
+	// Delete 删除文件，不存在时不报错
+	Delete(key string) error
+}
+
+// Hash 计算内容的 sha256，作为存储的键，同时返回内容长度
+func Hash(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// ValidKey 检查键的格式，避免拼接路径时越界
+func ValidKey(key string) bool {
+	return keyPattern.MatchString(key)
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DiffOp 差异片段的类型
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffInsert DiffOp = "insert"
+	DiffDelete DiffOp = "delete"
+)
+
+// DiffChunk 差异片段，相邻的同类型片段会被合并
+type DiffChunk struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxDiffTraceCells 限制 Myers 算法回溯表的大小，超过时退化为整体替换
+const maxDiffTraceCells = 16 << 20
+
+// diffEdit 编辑脚本中的一步，AIndex/BIndex 为对应的 token 下标（插入时 AIndex 无意义，删除时 BIndex 无意义）
+type diffEdit struct {
+	Op     DiffOp
+	AIndex int
+	BIndex int
+}
+
+// DiffLines 按行比较两段文本
+func DiffLines(a, b string) []DiffChunk {
+	return diffTokens(SplitLines(a), SplitLines(b))
+}
+
+// DiffWords 按词比较两段文本，中日韩文字按单字比较
+func DiffWords(a, b string) []DiffChunk {
+	return diffTokens(splitWords(a), splitWords(b))
+}
+
+// SplitLines 按行切分文本，每行保留结尾的换行符
+func SplitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// splitWords 将文本切分为单词、空白、标点以及单个中日韩字符
+func splitWords(s string) []string {
+	var tokens []string
+	start := -1
+	class := 0
+	for i, r := range s {
+		c := runeClass(r)
+		if start >= 0 && (c != class || c == 3) {
+			tokens = append(tokens, s[start:i])
+			start = -1
+		}
+		if start < 0 {
+			start, class = i, c
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+// runeClass 字符分类：0 单词字符，1 空白，2 标点符号，3 中日韩字符（每个字符单独成词）
+func runeClass(r rune) int {
+	switch {
+	case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+		return 3
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+		return 0
+	case unicode.IsSpace(r):
+		return 1
+	}
+	return 2
+}
+
+// diffTokens 比较两个 token 序列并合并为差异片段
+func diffTokens(a, b []string) []DiffChunk {
+	var chunks []DiffChunk
+	for _, e := range diffEdits(a, b) {
+		text := ""
+		if e.Op == DiffInsert {
+			text = b[e.BIndex]
+		} else {
+			text = a[e.AIndex]
+		}
+		if n := len(chunks); n > 0 && chunks[n-1].Op == e.Op {
+			chunks[n-1].Text += text
+			continue
+		}
+		chunks = append(chunks, DiffChunk{Op: e.Op, Text: text})
+	}
+	return chunks
+}
+
+// diffEdits 使用 Myers 算法计算最短编辑脚本
+func diffEdits(a, b []string) []diffEdit {
+	// 先去掉公共前缀和后缀，缩小计算规模
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]diffEdit, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, diffEdit{Op: DiffEqual, AIndex: i, BIndex: i})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix, prefix)...)
+	for i := suffix; i > 0; i-- {
+		edits = append(edits, diffEdit{Op: DiffEqual, AIndex: len(a) - i, BIndex: len(b) - i})
+	}
+	return edits
+}
+
+// myers 计算 a 到 b 的编辑脚本，aOff/bOff 为结果下标的偏移量
+func myers(a, b []string, aOff, bOff int) []diffEdit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	max := n + m
+	offset := max
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		if (d+1)*len(v) > maxDiffTraceCells {
+			return replaceAll(n, m, aOff, bOff)
+		}
+		trace = append(trace, append([]int(nil), v...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, n, m, offset, aOff, bOff)
+			}
+		}
+	}
+	return replaceAll(n, m, aOff, bOff)
+}
+
+// backtrack 根据回溯表还原编辑脚本
+func backtrack(trace [][]int, n, m, offset, aOff, bOff int) []diffEdit {
+	var reversed []diffEdit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, diffEdit{Op: DiffEqual, AIndex: aOff + x, BIndex: bOff + y})
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, diffEdit{Op: DiffInsert, BIndex: bOff + prevY})
+			} else {
+				reversed = append(reversed, diffEdit{Op: DiffDelete, AIndex: aOff + prevX})
+			}
+			x, y = prevX, prevY
+		}
+	}
+
+	edits := make([]diffEdit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
+
+// replaceAll 差异过大时退化为先全部删除再全部插入
+func replaceAll(n, m, aOff, bOff int) []diffEdit {
+	edits := make([]diffEdit, 0, n+m)
+	for i := 0; i < n; i++ {
+		edits = append(edits, diffEdit{Op: DiffDelete, AIndex: aOff + i})
+	}
+	for j := 0; j < m; j++ {
+		edits = append(edits, diffEdit{Op: DiffInsert, BIndex: bOff + j})
+	}
+	return edits
+}
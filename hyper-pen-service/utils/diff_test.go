@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: "", want: nil},
+		{in: "one", want: []string{"one"}},
+		{in: "one\n", want: []string{"one\n"}},
+		{in: "one\ntwo", want: []string{"one\n", "two"}},
+		{in: "\n\n", want: []string{"\n", "\n"}},
+	}
+
+	for _, tt := range tests {
+		if got := SplitLines(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitLines(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: "hello, world", want: []string{"hello", ",", " ", "world"}},
+		{in: "snake_case 42", want: []string{"snake_case", " ", "42"}},
+		{in: "你好world", want: []string{"你", "好", "world"}},
+		{in: "a  \tb", want: []string{"a", "  \t", "b"}},
+		{in: "ひらがなカナ", want: []string{"ひ", "ら", "が", "な", "カ", "ナ"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitWords(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []DiffChunk
+	}{
+		{name: "both empty", a: "", b: "", want: nil},
+		{name: "identical", a: "a\nb\n", b: "a\nb\n", want: []DiffChunk{{DiffEqual, "a\nb\n"}}},
+		{name: "all inserted", a: "", b: "a\nb\n", want: []DiffChunk{{DiffInsert, "a\nb\n"}}},
+		{name: "all deleted", a: "a\nb\n", b: "", want: []DiffChunk{{DiffDelete, "a\nb\n"}}},
+		{
+			name: "changed middle line",
+			a:    "a\nb\nc\n",
+			b:    "a\nx\nc\n",
+			want: []DiffChunk{{DiffEqual, "a\n"}, {DiffDelete, "b\n"}, {DiffInsert, "x\n"}, {DiffEqual, "c\n"}},
+		},
+		{
+			name: "appended line",
+			a:    "a\nb\n",
+			b:    "a\nb\nc\n",
+			want: []DiffChunk{{DiffEqual, "a\nb\n"}, {DiffInsert, "c\n"}},
+		},
+		{
+			name: "missing trailing newline",
+			a:    "a\nb",
+			b:    "a\nb\n",
+			want: []DiffChunk{{DiffEqual, "a\n"}, {DiffDelete, "b"}, {DiffInsert, "b\n"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffLines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffLines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []DiffChunk
+	}{
+		{
+			name: "replaced word",
+			a:    "the quick fox",
+			b:    "the slow fox",
+			want: []DiffChunk{{DiffEqual, "the "}, {DiffDelete, "quick"}, {DiffInsert, "slow"}, {DiffEqual, " fox"}},
+		},
+		{
+			name: "inserted word",
+			a:    "hello world",
+			b:    "hello there world",
+			want: []DiffChunk{{DiffEqual, "hello "}, {DiffInsert, "there "}, {DiffEqual, "world"}},
+		},
+		{
+			name: "single han character",
+			a:    "你好世界",
+			b:    "你好中国",
+			want: []DiffChunk{{DiffEqual, "你好"}, {DiffDelete, "世界"}, {DiffInsert, "中国"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffWords(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffWords = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// lcsLength 用动态规划计算最长公共子序列的长度，用于校验 Myers 结果是最短编辑脚本
+func lcsLength(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] > dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+func TestDiffEditsMinimal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	alphabet := []string{"a", "b", "c", "d"}
+	randomTokens := func() []string {
+		tokens := make([]string, r.Intn(12))
+		for i := range tokens {
+			tokens[i] = alphabet[r.Intn(len(alphabet))]
+		}
+		return tokens
+	}
+
+	for i := 0; i < 1000; i++ {
+		a, b := randomTokens(), randomTokens()
+		edits := diffEdits(a, b)
+
+		var gotA, gotB []string
+		equal := 0
+		for _, e := range edits {
+			switch e.Op {
+			case DiffEqual:
+				if a[e.AIndex] != b[e.BIndex] {
+					t.Fatalf("diffEdits(%q, %q): equal edit pairs %q with %q", a, b, a[e.AIndex], b[e.BIndex])
+				}
+				gotA = append(gotA, a[e.AIndex])
+				gotB = append(gotB, b[e.BIndex])
+				equal++
+			case DiffDelete:
+				gotA = append(gotA, a[e.AIndex])
+			case DiffInsert:
+				gotB = append(gotB, b[e.BIndex])
+			}
+		}
+
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("diffEdits(%q, %q) does not reproduce the inputs: %v", a, b, edits)
+		}
+		if want := lcsLength(a, b); equal != want {
+			t.Fatalf("diffEdits(%q, %q) keeps %d tokens, want %d", a, b, equal, want)
+		}
+	}
+}
+
+func TestDiffEditsFallback(t *testing.T) {
+	// 完全不同的两段长文本超过回溯表上限时退化为整体替换，结果仍然正确
+	a := make([]string, 3000)
+	b := make([]string, 3000)
+	for i := range a {
+		a[i] = "a"
+		b[i] = "b"
+	}
+	edits := diffEdits(a, b)
+	if len(edits) != len(a)+len(b) {
+		t.Fatalf("len(edits) = %d, want %d", len(edits), len(a)+len(b))
+	}
+	for i, e := range edits {
+		want := DiffDelete
+		if i >= len(a) {
+			want = DiffInsert
+		}
+		if e.Op != want {
+			t.Fatalf("edits[%d].Op = %s, want %s", i, e.Op, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter Markdown 笔记文件的 YAML 头信息
+type FrontMatter struct {
+	ID        string     `yaml:"id,omitempty"`
+	Title     string     `yaml:"title,omitempty"`
+	Category  string     `yaml:"category,omitempty"`
+	Tags      StringList `yaml:"tags,omitempty"`
+	CreatedAt time.Time  `yaml:"created_at,omitempty"`
+	UpdatedAt time.Time  `yaml:"updated_at,omitempty"`
+}
+
+// StringList 既可以写成 YAML 列表，也可以写成逗号分隔的字符串
+type StringList []string
+
+// UnmarshalYAML 实现 yaml.Unmarshaler
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var items []string
+		for _, item := range strings.Split(value.Value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*l = items
+		return nil
+	}
+
+	var items []string
+	if err := value.Decode(&items); err != nil {
+		return err
+	}
+	*l = items
+	return nil
+}
+
+// ParseFrontMatter 拆分 Markdown 文本中的 YAML 头信息和正文，没有头信息时返回空的 FrontMatter
+func ParseFrontMatter(data []byte) (*FrontMatter, string, error) {
+	var fm FrontMatter
+
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if !strings.HasPrefix(text, "---\n") {
+		return &fm, text, nil
+	}
+
+	// 查找结束分隔符 "---" 或 "..."
+	lines := strings.SplitAfter(text[len("---\n"):], "\n")
+	offset := len("---\n")
+	for i, line := range lines {
+		if trimmed := strings.TrimRight(line, "\n"); trimmed != "---" && trimmed != "..." {
+			continue
+		}
+
+		header := strings.Join(lines[:i], "")
+		if err := yaml.Unmarshal([]byte(header), &fm); err != nil {
+			return nil, "", err
+		}
+		body := text[offset+len(header)+len(line):]
+		return &fm, strings.TrimPrefix(body, "\n"), nil
+	}
+
+	// 没有结束分隔符，按普通正文处理
+	return &fm, text, nil
+}
+
+// RenderFrontMatter 生成带 YAML 头信息的 Markdown 文本
+func RenderFrontMatter(fm *FrontMatter, body string) ([]byte, error) {
+	header, err := yaml.Marshal(fm)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	buf.Write(header)
+	buf.WriteString("---\n\n")
+	buf.WriteString(body)
+	return buf.Bytes(), nil
+}
@@ -10,6 +10,8 @@ import (
 
 type Claims struct {
 	UserID uint `json:"user_id"`
+	// RegistrationID 非空表示这是待完成注册的受限令牌，只能用于完成注册
+	RegistrationID string `json:"registration_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -27,6 +29,21 @@ func GenerateToken(user *models.User) (string, error) {
 	return token.SignedString([]byte(config.AppConfig.JWTSecret))
 }
 
+// GenerateRegistrationToken 为待完成的注册签发受限令牌，与注册记录同时过期
+func GenerateRegistrationToken(registration *models.PendingRegistration) (string, error) {
+	claims := Claims{
+		RegistrationID: registration.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(registration.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(config.AppConfig.JWTSecret))
+}
+
 func ParseToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(config.AppConfig.JWTSecret), nil
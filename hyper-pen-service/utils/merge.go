@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"strings"
+)
+
+// 冲突标记
+const (
+	conflictStart  = "<<<<<<< yours\n"
+	conflictMiddle = "=======\n"
+	conflictEnd    = ">>>>>>> server\n"
+)
+
+// MergeResult 三方合并的结果，Conflicts 为冲突块数量，冲突处使用 git 风格的标记
+type MergeResult struct {
+	Content   string `json:"content"`
+	Conflicts int    `json:"conflicts"`
+}
+
+// Merge3 以 base 为共同祖先按行合并 ours（客户端）和 theirs（服务器）两份修改
+func Merge3(base, ours, theirs string) MergeResult {
+	baseLines, ourLines, theirLines := SplitLines(base), SplitLines(ours), SplitLines(theirs)
+	ourMatch := matchLines(baseLines, ourLines)
+	theirMatch := matchLines(baseLines, theirLines)
+
+	var out strings.Builder
+	conflicts := 0
+	i, j, k := 0, 0, 0
+	for {
+		// 三方一致的稳定区间
+		n := 0
+		for i+n < len(baseLines) && ourMatch[i+n] == j+n && theirMatch[i+n] == k+n {
+			n++
+		}
+		if n > 0 {
+			writeLines(&out, baseLines[i:i+n])
+			i, j, k = i+n, j+n, k+n
+			continue
+		}
+
+		// 查找下一个三方都能对齐的基准行
+		next := i
+		for next < len(baseLines) && (ourMatch[next] < j || theirMatch[next] < k) {
+			next++
+		}
+
+		baseEnd, ourEnd, theirEnd := len(baseLines), len(ourLines), len(theirLines)
+		if next < len(baseLines) {
+			baseEnd, ourEnd, theirEnd = next, ourMatch[next], theirMatch[next]
+		}
+		if i == baseEnd && j == ourEnd && k == theirEnd {
+			break
+		}
+
+		b, o, t := baseLines[i:baseEnd], ourLines[j:ourEnd], theirLines[k:theirEnd]
+		switch {
+		case equalLines(o, b):
+			writeLines(&out, t)
+		case equalLines(t, b), equalLines(o, t):
+			writeLines(&out, o)
+		default:
+			conflicts++
+			out.WriteString(conflictStart)
+			writeConflictSide(&out, o)
+			out.WriteString(conflictMiddle)
+			writeConflictSide(&out, t)
+			out.WriteString(conflictEnd)
+		}
+		i, j, k = baseEnd, ourEnd, theirEnd
+	}
+
+	return MergeResult{Content: out.String(), Conflicts: conflicts}
+}
+
+// matchLines 返回 base 中每一行在 other 中对应的行号，没有对应时为 -1
+func matchLines(base, other []string) []int {
+	match := make([]int, len(base))
+	for i := range match {
+		match[i] = -1
+	}
+	for _, e := range diffEdits(base, other) {
+		if e.Op == DiffEqual {
+			match[e.AIndex] = e.BIndex
+		}
+	}
+	return match
+}
+
+// equalLines 判断两组行是否完全相同
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
+
+// writeConflictSide 写入冲突的一侧，保证冲突标记独占一行
+func writeConflictSide(out *strings.Builder, lines []string) {
+	writeLines(out, lines)
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		out.WriteString("\n")
+	}
+}
@@ -0,0 +1,101 @@
+package utils
+
+import "testing"
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		conflicts int
+	}{
+		{
+			name: "no changes",
+			base: "a\nb\n", ours: "a\nb\n", theirs: "a\nb\n",
+			want: "a\nb\n",
+		},
+		{
+			name: "only ours changed",
+			base: "a\nb\nc\n", ours: "a\nB\nc\n", theirs: "a\nb\nc\n",
+			want: "a\nB\nc\n",
+		},
+		{
+			name: "only theirs changed",
+			base: "a\nb\nc\n", ours: "a\nb\nc\n", theirs: "a\nb\nC\n",
+			want: "a\nb\nC\n",
+		},
+		{
+			name: "changes in different lines",
+			base: "a\nb\nc\nd\n", ours: "A\nb\nc\nd\n", theirs: "a\nb\nc\nD\n",
+			want: "A\nb\nc\nD\n",
+		},
+		{
+			name: "same change on both sides",
+			base: "a\nb\nc\n", ours: "a\nX\nc\n", theirs: "a\nX\nc\n",
+			want: "a\nX\nc\n",
+		},
+		{
+			name: "ours appends and theirs prepends",
+			base: "b\n", ours: "b\nc\n", theirs: "a\nb\n",
+			want: "a\nb\nc\n",
+		},
+		{
+			name: "ours deletes a line theirs did not touch",
+			base: "a\nb\nc\n", ours: "a\nc\n", theirs: "a\nb\nc\nd\n",
+			want: "a\nc\nd\n",
+		},
+		{
+			name: "empty base with identical content",
+			base: "", ours: "same\n", theirs: "same\n",
+			want: "same\n",
+		},
+		{
+			name: "conflicting edits of the same line",
+			base: "a\nb\nc\n", ours: "a\nours\nc\n", theirs: "a\ntheirs\nc\n",
+			want:      "a\n<<<<<<< yours\nours\n=======\ntheirs\n>>>>>>> server\nc\n",
+			conflicts: 1,
+		},
+		{
+			name: "edit against delete",
+			base: "a\nb\nc\n", ours: "a\nB\nc\n", theirs: "a\nc\n",
+			want:      "a\n<<<<<<< yours\nB\n=======\n>>>>>>> server\nc\n",
+			conflicts: 1,
+		},
+		{
+			name: "both insert different lines at the end",
+			base: "a\n", ours: "a\nx\n", theirs: "a\ny\n",
+			want:      "a\n<<<<<<< yours\nx\n=======\ny\n>>>>>>> server\n",
+			conflicts: 1,
+		},
+		{
+			name: "two separate conflicts",
+			base: "a\nb\nc\nd\ne\n", ours: "a\nB1\nc\nD1\ne\n", theirs: "a\nB2\nc\nD2\ne\n",
+			want: "a\n<<<<<<< yours\nB1\n=======\nB2\n>>>>>>> server\n" +
+				"c\n<<<<<<< yours\nD1\n=======\nD2\n>>>>>>> server\ne\n",
+			conflicts: 2,
+		},
+		{
+			name: "conflict without trailing newline keeps markers on their own line",
+			base: "a\nb", ours: "a\nours", theirs: "a\ntheirs",
+			want:      "a\n<<<<<<< yours\nours\n=======\ntheirs\n>>>>>>> server\n",
+			conflicts: 1,
+		},
+		{
+			name: "empty base with different content",
+			base: "", ours: "mine\n", theirs: "server\n",
+			want:      "<<<<<<< yours\nmine\n=======\nserver\n>>>>>>> server\n",
+			conflicts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Merge3(tt.base, tt.ours, tt.theirs)
+			if got.Content != tt.want || got.Conflicts != tt.conflicts {
+				t.Errorf("Merge3 = %q (%d conflicts), want %q (%d conflicts)", got.Content, got.Conflicts, tt.want, tt.conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// WikiLink 正文中的 [[目标]] 或 [[目标|别名]] 链接，目标可以是笔记标题或笔记ID
+type WikiLink struct {
+	Target string
+	Alias  string
+}
+
+var (
+	wikiLinkPattern   = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]*))?\]\]`)
+	inlineCodePattern = regexp.MustCompile("`[^`\n]*`")
+)
+
+// ParseWikiLinks 提取正文中的双链，忽略代码块和行内代码中的内容。同一目标（不区分大小写）只返回第一次出现
+func ParseWikiLinks(content string) []WikiLink {
+	var links []WikiLink
+	seen := make(map[string]bool)
+
+	mapProse(content, func(text string) string {
+		for _, m := range wikiLinkPattern.FindAllStringSubmatch(text, -1) {
+			target := strings.TrimSpace(m[1])
+			if target == "" {
+				continue
+			}
+			key := strings.ToLower(target)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			links = append(links, WikiLink{Target: target, Alias: strings.TrimSpace(m[2])})
+		}
+		return text
+	})
+	return links
+}
+
+// RenameWikiLinks 把指向 oldTarget（不区分大小写）的双链改为指向 newTarget，保留原有别名；
+// 原链接没有别名时使用 defaultAlias。返回修改后的正文和替换的链接数量
+func RenameWikiLinks(content, oldTarget, newTarget, defaultAlias string) (string, int) {
+	replaced := 0
+	result := mapProse(content, func(text string) string {
+		return wikiLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+			m := wikiLinkPattern.FindStringSubmatch(link)
+			if !strings.EqualFold(strings.TrimSpace(m[1]), oldTarget) {
+				return link
+			}
+			replaced++
+			alias := strings.TrimSpace(m[2])
+			if alias == "" {
+				alias = defaultAlias
+			}
+			if alias == "" {
+				return "[[" + newTarget + "]]"
+			}
+			return "[[" + newTarget + "|" + alias + "]]"
+		})
+	})
+	return result, replaced
+}
+
+// ValidWikiLinkTarget 标题能否直接作为 [[标题]] 书写
+func ValidWikiLinkTarget(title string) bool {
+	title = strings.TrimSpace(title)
+	return title != "" && !strings.ContainsAny(title, "[]|\n")
+}
+
+// mapProse 对不在围栏代码块和行内代码中的文本应用 fn，其余内容原样保留
+func mapProse(content string, fn func(string) string) string {
+	lines := strings.Split(content, "\n")
+	fence := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+
+		var b strings.Builder
+		last := 0
+		for _, span := range inlineCodePattern.FindAllStringIndex(line, -1) {
+			b.WriteString(fn(line[last:span[0]]))
+			b.WriteString(line[span[0]:span[1]])
+			last = span[1]
+		}
+		b.WriteString(fn(line[last:]))
+		lines[i] = b.String()
+	}
+	return strings.Join(lines, "\n")
+}
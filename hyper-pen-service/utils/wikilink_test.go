@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWikiLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []WikiLink
+	}{
+		{name: "no links", content: "plain text [not a link]", want: nil},
+		{name: "title", content: "see [[Go Notes]] here", want: []WikiLink{{Target: "Go Notes"}}},
+		{name: "alias", content: "see [[Go Notes|the notes]]", want: []WikiLink{{Target: "Go Notes", Alias: "the notes"}}},
+		{name: "trims spaces", content: "[[  Go Notes  |  alias ]]", want: []WikiLink{{Target: "Go Notes", Alias: "alias"}}},
+		{name: "empty alias", content: "[[Go Notes|]]", want: []WikiLink{{Target: "Go Notes"}}},
+		{
+			name:    "several links",
+			content: "[[A]] and [[B|b]]\nthen [[中文标题]]",
+			want:    []WikiLink{{Target: "A"}, {Target: "B", Alias: "b"}, {Target: "中文标题"}},
+		},
+		{
+			name:    "duplicates ignore case and keep the first",
+			content: "[[Go]] [[go|alias]] [[GO]]",
+			want:    []WikiLink{{Target: "Go"}},
+		},
+		{name: "blank target", content: "[[   ]] [[ |alias]]", want: nil},
+		{name: "no line breaks inside", content: "[[broken\nlink]]", want: nil},
+		{name: "nested brackets", content: "[[[inner]]]", want: []WikiLink{{Target: "inner"}}},
+		{name: "inline code", content: "`[[Code]]` and [[Prose]]", want: []WikiLink{{Target: "Prose"}}},
+		{
+			name:    "fenced code block",
+			content: "```go\n[[InFence]]\n```\n[[After]]",
+			want:    []WikiLink{{Target: "After"}},
+		},
+		{
+			name:    "tilde fence closes only with tildes",
+			content: "~~~\n```\n[[Inside]]\n~~~\n[[Outside]]",
+			want:    []WikiLink{{Target: "Outside"}},
+		},
+		{
+			name:    "unclosed fence hides the rest",
+			content: "[[Before]]\n```\n[[Hidden]]",
+			want:    []WikiLink{{Target: "Before"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseWikiLinks(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWikiLinks = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameWikiLinks(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		oldTarget    string
+		newTarget    string
+		defaultAlias string
+		want         string
+		replaced     int
+	}{
+		{
+			name:    "simple rename",
+			content: "see [[Old]]", oldTarget: "Old", newTarget: "New",
+			want: "see [[New]]", replaced: 1,
+		},
+		{
+			name:    "keeps alias",
+			content: "see [[Old|my alias]]", oldTarget: "Old", newTarget: "New",
+			want: "see [[New|my alias]]", replaced: 1,
+		},
+		{
+			name:    "case insensitive with spaces",
+			content: "[[ old ]] and [[OLD|x]]", oldTarget: "Old", newTarget: "New",
+			want: "[[New]] and [[New|x]]", replaced: 2,
+		},
+		{
+			name:    "default alias for links without one",
+			content: "[[Old]] [[Old|kept]]", oldTarget: "Old", newTarget: "note-id", defaultAlias: "Old",
+			want: "[[note-id|Old]] [[note-id|kept]]", replaced: 2,
+		},
+		{
+			name:    "other links untouched",
+			content: "[[Older]] [[Other|Old]]", oldTarget: "Old", newTarget: "New",
+			want: "[[Older]] [[Other|Old]]", replaced: 0,
+		},
+		{
+			name:    "code is untouched",
+			content: "`[[Old]]`\n```\n[[Old]]\n```\n[[Old]]", oldTarget: "Old", newTarget: "New",
+			want: "`[[Old]]`\n```\n[[Old]]\n```\n[[New]]", replaced: 1,
+		},
+		{
+			name:    "preserves line endings",
+			content: "a\n\n[[Old]]\n", oldTarget: "Old", newTarget: "New",
+			want: "a\n\n[[New]]\n", replaced: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, replaced := RenameWikiLinks(tt.content, tt.oldTarget, tt.newTarget, tt.defaultAlias)
+			if got != tt.want || replaced != tt.replaced {
+				t.Errorf("RenameWikiLinks = %q (%d), want %q (%d)", got, replaced, tt.want, tt.replaced)
+			}
+		})
+	}
+}
+
+func TestValidWikiLinkTarget(t *testing.T) {
+	tests := []struct {
+		title string
+		want  bool
+	}{
+		{title: "Go Notes", want: true},
+		{title: "中文标题", want: true},
+		{title: "", want: false},
+		{title: "   ", want: false},
+		{title: "a|b", want: false},
+		{title: "a[b", want: false},
+		{title: "a]b", want: false},
+		{title: "line\nbreak", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidWikiLinkTarget(tt.title); got != tt.want {
+			t.Errorf("ValidWikiLinkTarget(%q) = %v, want %v", tt.title, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ import { createRouter, createWebHistory } from 'vue-router'
 import Home from '@/views/Home.vue'
 import Login from '@/views/Login.vue'
 import Register from '@/views/Register.vue'
+import OAuthCallback from '@/views/OAuthCallback.vue'
 import NoteList from '@/views/NoteList.vue'
 import SharedNote from '../views/SharedNote.vue'
 
@@ -23,6 +24,11 @@ const routes = [
     component: Register,
     meta: { requiresGuest: true }
   },
+  {
+    path: '/auth/callback',
+    name: 'oauthCallback',
+    component: OAuthCallback
+  },
   {
     path: '/notes',
     name: 'notes',